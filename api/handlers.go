@@ -0,0 +1,338 @@
+// Package api implements read-only HTTP endpoints that export results as JSON or CSV.
+//
+//	/<project>/api/results?bench=B&metric=M&machine=X&procs=N&from=R1&to=R2&offset=O&limit=L&format=F
+//	/<project>/api/rev?id=R&format=F
+//	/<project>/api/compare?a=R1&b=R2&format=F
+//
+// All filters are optional. from and to are revision ids that limit the range
+// of revisions (inclusive) in topological order. Results are ordered by benchmark,
+// machine, metric, GOMAXPROCS and revision order. format is json (default) or csv.
+// compare compares all series at revisions a and b (see regress.Compare),
+// a and b can be revision ids, branches or tags, b defaults to the branch head.
+// Long responses are split into pages of limit results, the next page is referenced
+// by the Next field in JSON or by the Link header.
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/regress"
+	"code.google.com/p/goperfd/repo"
+)
+
+const (
+	defaultLimit = 1000
+	maxLimit     = 10000
+)
+
+// Result is an exported result.
+type Result struct {
+	Rev       string
+	Index     int // position of the revision in topological order
+	Benchmark string
+	Machine   string
+	Metric    string
+	Procs     int
+	Value     uint64
+}
+
+// Comparison is a JSON response of /api/compare.
+type Comparison struct {
+	Project     string
+	A           string
+	B           string
+	Comparisons []*regress.Comparison
+}
+
+// Page is a JSON response.
+type Page struct {
+	Project string
+	Total   int // total number of results that match the query
+	Offset  int
+	Results []*Result
+	Next    string `json:",omitempty"` // URL of the next page
+}
+
+// handlers serves results of a single project.
+type handlers struct {
+	project *config.ProjectFile
+	store   db.Store
+	repo    *repo.Repo
+}
+
+// RegisterHandlers registers endpoints of the project under its URL path.
+func RegisterHandlers(project *config.ProjectFile, s db.Store, r *repo.Repo) error {
+	h := &handlers{project: project, store: s, repo: r}
+	prefix := project.Get().URLPath()
+	http.HandleFunc(prefix+"/api/results", h.handleResults)
+	http.HandleFunc(prefix+"/api/rev", h.handleRev)
+	http.HandleFunc(prefix+"/api/compare", h.handleCompare)
+	return nil
+}
+
+func (h *handlers) handleResults(w http.ResponseWriter, r *http.Request) {
+	cfg := h.project.Get()
+	p, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	procs, err := intParam(r, "procs", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := &db.Query{
+		Project:   cfg.Name,
+		Benchmark: r.FormValue("bench"),
+		Metric:    r.FormValue("metric"),
+		Machine:   r.FormValue("machine"),
+		Procs:     procs,
+	}
+	from, to := 0, -1
+	if id := r.FormValue("from"); id != "" {
+		rev := h.repo.Rev(id)
+		if rev == nil {
+			http.Error(w, fmt.Sprintf("unknown revision '%v'", id), http.StatusBadRequest)
+			return
+		}
+		from = rev.Index
+	}
+	if id := r.FormValue("to"); id != "" {
+		rev := h.repo.Rev(id)
+		if rev == nil {
+			http.Error(w, fmt.Sprintf("unknown revision '%v'", id), http.StatusBadRequest)
+			return
+		}
+		to = rev.Index
+	}
+	results, err := h.store.Select(q)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	var res []*Result
+	for _, x := range h.export(results) {
+		if x.Index >= from && (to < 0 || x.Index <= to) {
+			res = append(res, x)
+		}
+	}
+	serve(w, r, p, cfg.Name, res)
+}
+
+func (h *handlers) handleRev(w http.ResponseWriter, r *http.Request) {
+	cfg := h.project.Get()
+	p, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rev := h.repo.Rev(r.FormValue("id"))
+	if rev == nil {
+		http.Error(w, fmt.Sprintf("unknown revision '%v'", r.FormValue("id")), http.StatusNotFound)
+		return
+	}
+	results, err := h.store.Revision(cfg.Name, rev.Id)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	serve(w, r, p, cfg.Name, h.export(results))
+}
+
+func (h *handlers) handleCompare(w http.ResponseWriter, r *http.Request) {
+	cfg := h.project.Get()
+	format, err := formatParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("a") == "" {
+		http.Error(w, "no revision a", http.StatusBadRequest)
+		return
+	}
+	a, err := h.repo.Resolve(r.FormValue("a"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	b, err := h.repo.Resolve(r.FormValue("b"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	cmps, err := regress.Compare(cfg, h.store, h.repo.Revs(), a, b)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		res := &Comparison{Project: cfg.Name, A: a.Id, B: b.Id, Comparisons: cmps}
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Printf("api: failed to write response: %v", err)
+		}
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"benchmark", "machine", "metric", "procs", "rev_a", "rev_b", "old", "new", "delta", "noise", "significant", "regression"})
+		for _, c := range cmps {
+			cw.Write([]string{c.Benchmark, c.Machine, c.Metric, strconv.Itoa(c.Procs), c.RevA, c.RevB,
+				strconv.FormatFloat(c.Old, 'f', -1, 64), strconv.FormatFloat(c.New, 'f', -1, 64),
+				strconv.FormatFloat(c.Delta, 'f', 4, 64), strconv.FormatFloat(c.Noise, 'f', 4, 64),
+				strconv.FormatBool(c.Significant), strconv.FormatBool(c.Regression)})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			log.Printf("api: failed to write response: %v", err)
+		}
+	}
+}
+
+// export converts results for known revisions and sorts them.
+func (h *handlers) export(results []*db.Result) []*Result {
+	var res []*Result
+	for _, r := range results {
+		rev := h.repo.Rev(r.Rev)
+		if rev == nil {
+			continue
+		}
+		res = append(res, &Result{
+			Rev:       r.Rev,
+			Index:     rev.Index,
+			Benchmark: r.Benchmark,
+			Machine:   r.Machine,
+			Metric:    r.Metric,
+			Procs:     r.Procs,
+			Value:     r.Value,
+		})
+	}
+	sort.Sort(resultSlice(res))
+	return res
+}
+
+// paging is the requested page of results and its format.
+type paging struct {
+	offset int
+	limit  int
+	format string
+}
+
+// pageParams parses and checks the paging parameters. Handlers call it before
+// they query the store, so that bad requests are rejected early.
+func pageParams(r *http.Request) (*paging, error) {
+	format, err := formatParam(r)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := intParam(r, "offset", 0)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := intParam(r, "limit", defaultLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("bad limit value '%v'", limit)
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return &paging{offset, limit, format}, nil
+}
+
+// serve writes a page of results in the requested format.
+func serve(w http.ResponseWriter, r *http.Request, p *paging, project string, results []*Result) {
+	page := &Page{Project: project, Total: len(results), Offset: p.offset}
+	if p.offset < len(results) {
+		page.Results = results[p.offset:]
+	}
+	if len(page.Results) > p.limit {
+		page.Results = page.Results[:p.limit]
+		next := *r.URL
+		v := next.Query()
+		v.Set("offset", strconv.Itoa(p.offset+p.limit))
+		v.Set("limit", strconv.Itoa(p.limit))
+		next.RawQuery = v.Encode()
+		page.Next = next.String()
+		w.Header().Set("Link", fmt.Sprintf("<%v>; rel=\"next\"", page.Next))
+	}
+	switch p.format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(page); err != nil {
+			log.Printf("api: failed to write response: %v", err)
+		}
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"rev", "index", "benchmark", "machine", "metric", "procs", "value"})
+		for _, x := range page.Results {
+			cw.Write([]string{x.Rev, strconv.Itoa(x.Index), x.Benchmark, x.Machine, x.Metric,
+				strconv.Itoa(x.Procs), strconv.FormatUint(x.Value, 10)})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			log.Printf("api: failed to write response: %v", err)
+		}
+	}
+}
+
+// formatParam returns the requested format, json or csv.
+func formatParam(r *http.Request) (string, error) {
+	switch f := r.FormValue("format"); f {
+	case "", "json":
+		return "json", nil
+	case "csv":
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format '%v'", f)
+	}
+}
+
+func intParam(r *http.Request, name string, def int) (int, error) {
+	s := r.FormValue(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("bad %v value '%v'", name, s)
+	}
+	return v, nil
+}
+
+func serveError(w http.ResponseWriter, err error) {
+	log.Printf("api: %v", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+type resultSlice []*Result
+
+func (p resultSlice) Len() int      { return len(p) }
+func (p resultSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p resultSlice) Less(i, j int) bool {
+	a, b := p[i], p[j]
+	switch {
+	case a.Benchmark != b.Benchmark:
+		return a.Benchmark < b.Benchmark
+	case a.Machine != b.Machine:
+		return a.Machine < b.Machine
+	case a.Metric != b.Metric:
+		return a.Metric < b.Metric
+	case a.Procs != b.Procs:
+		return a.Procs < b.Procs
+	default:
+		return a.Index < b.Index
+	}
+}
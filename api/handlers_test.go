@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/repo"
+)
+
+// countStore counts queries, so that tests can check that bad requests
+// are rejected before the store is queried.
+type countStore struct {
+	db.Store
+	queries int
+}
+
+func (s *countStore) Select(q *db.Query) ([]*db.Result, error) {
+	s.queries++
+	return s.Store.Select(q)
+}
+
+func (s *countStore) Revision(project, rev string) ([]*db.Result, error) {
+	s.queries++
+	return s.Store.Revision(project, rev)
+}
+
+// newTestHandlers creates handlers over a git repository with n linear commits.
+// Every revision has results of benchmarks json and http with GOMAXPROCS 1 and 4.
+func newTestHandlers(t *testing.T, n int) (*handlers, *countStore, func()) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "goperfd-api-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = filepath.Join(dir, "repo")
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Gopher", "GIT_AUTHOR_EMAIL=gopher@example.com",
+			"GIT_COMMITTER_NAME=Gopher", "GIT_COMMITTER_EMAIL=gopher@example.com", "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	os.Mkdir(filepath.Join(dir, "repo"), 0750)
+	git("init", "--quiet")
+	for i := 0; i < n; i++ {
+		git("commit", "--quiet", "--allow-empty", "-m", fmt.Sprintf("commit %v", i))
+	}
+	r, err := repo.Open(filepath.Join(dir, "repo"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := db.Open(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []*db.Result
+	for i, rev := range r.Revs() {
+		for _, bench := range []string{"json", "http"} {
+			for _, procs := range []int{1, 4} {
+				results = append(results, &db.Result{Project: "test", Rev: rev.Id, Benchmark: bench,
+					Machine: "m1", Metric: "time", Procs: procs, Value: uint64(1000*procs + i)})
+			}
+		}
+	}
+	if err := store.Add(results); err != nil {
+		t.Fatal(err)
+	}
+	project := new(config.ProjectFile)
+	project.Set(&config.ProjectConfig{Name: "test"})
+	cs := &countStore{Store: store}
+	return &handlers{project: project, store: cs, repo: r}, cs, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func get(h http.HandlerFunc, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", url, nil))
+	return w
+}
+
+func getPage(t *testing.T, h http.HandlerFunc, url string) *Page {
+	w := get(h, url)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%v: status %v: %s", url, w.Code, w.Body)
+	}
+	page := new(Page)
+	if err := json.Unmarshal(w.Body.Bytes(), page); err != nil {
+		t.Fatalf("%v: %v", url, err)
+	}
+	if link := w.Header().Get("Link"); (link != "") != (page.Next != "") || !strings.Contains(link, page.Next) {
+		t.Errorf("%v: Link header '%v' does not match next page '%v'", url, link, page.Next)
+	}
+	return page
+}
+
+func TestResultsPaging(t *testing.T) {
+	h, _, cleanup := newTestHandlers(t, 5)
+	defer cleanup()
+	all := getPage(t, h.handleResults, "/test/api/results")
+	if all.Total != 20 || len(all.Results) != 20 || all.Next != "" {
+		t.Fatalf("got %v of %v results, next page '%v'", len(all.Results), all.Total, all.Next)
+	}
+	for i := 1; i < len(all.Results); i++ {
+		if resultSlice(all.Results).Less(i, i-1) {
+			t.Fatalf("results are not sorted: %+v before %+v", all.Results[i-1], all.Results[i])
+		}
+	}
+	var got []*Result
+	pages := 0
+	for url := "/test/api/results?limit=6"; url != ""; pages++ {
+		page := getPage(t, h.handleResults, url)
+		if page.Total != 20 || page.Offset != len(got) {
+			t.Fatalf("%v: got total %v, offset %v", url, page.Total, page.Offset)
+		}
+		got = append(got, page.Results...)
+		url = page.Next
+	}
+	if pages != 4 || len(got) != len(all.Results) {
+		t.Fatalf("got %v results in %v pages, want 20 in 4", len(got), pages)
+	}
+	for i := range got {
+		if *got[i] != *all.Results[i] {
+			t.Errorf("result #%v: got %+v, want %+v", i, got[i], all.Results[i])
+		}
+	}
+	if page := getPage(t, h.handleResults, "/test/api/results?offset=100"); page.Total != 20 || len(page.Results) != 0 {
+		t.Errorf("offset past the end: got %v of %v results", len(page.Results), page.Total)
+	}
+	if page := getPage(t, h.handleResults, fmt.Sprintf("/test/api/results?limit=%v", maxLimit+1)); len(page.Results) != 20 {
+		t.Errorf("limit above max: got %v results", len(page.Results))
+	}
+}
+
+func TestResultsFilters(t *testing.T) {
+	h, _, cleanup := newTestHandlers(t, 5)
+	defer cleanup()
+	revs := h.repo.Revs()
+	for _, c := range []struct {
+		query string
+		n     int
+	}{
+		{"bench=json", 10},
+		{"procs=4", 10},
+		{"machine=m1&metric=time", 20},
+		{"machine=m2", 0},
+		{"bench=json&procs=1", 5},
+		{"from=" + revs[3].Id, 8},
+		{"to=" + revs[1].Id, 8},
+		{"bench=http&procs=4&from=" + revs[1].Id + "&to=" + revs[2].Id, 2},
+	} {
+		page := getPage(t, h.handleResults, "/test/api/results?"+c.query)
+		if page.Total != c.n || len(page.Results) != c.n {
+			t.Errorf("%v: got %v of %v results, want %v", c.query, len(page.Results), page.Total, c.n)
+			continue
+		}
+		for _, x := range page.Results {
+			if strings.Contains(c.query, "bench=json") && x.Benchmark != "json" ||
+				strings.Contains(c.query, "procs=4") && x.Procs != 4 ||
+				strings.Contains(c.query, "from=") && x.Index < 1 {
+				t.Errorf("%v: unexpected result %+v", c.query, x)
+			}
+		}
+	}
+	page := getPage(t, h.handleRev, "/test/api/rev?id="+revs[2].Id)
+	if page.Total != 4 {
+		t.Errorf("got %v results of revision, want 4", page.Total)
+	}
+	for _, x := range page.Results {
+		if x.Rev != revs[2].Id || x.Index != 2 || x.Value != uint64(1000*x.Procs+2) {
+			t.Errorf("bad result of revision: %+v", x)
+		}
+	}
+}
+
+func TestResultsCSV(t *testing.T) {
+	h, _, cleanup := newTestHandlers(t, 3)
+	defer cleanup()
+	revs := h.repo.Revs()
+	w := get(h.handleResults, "/test/api/results?bench=json&procs=4&format=csv&limit=2")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("status %v, content type '%v': %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	if !strings.Contains(w.Header().Get("Link"), "offset=2") || !strings.Contains(w.Header().Get("Link"), "format=csv") {
+		t.Errorf("bad Link header '%v'", w.Header().Get("Link"))
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"rev", "index", "benchmark", "machine", "metric", "procs", "value"},
+		{revs[0].Id, "0", "json", "m1", "time", "4", "4000"},
+		{revs[1].Id, "1", "json", "m1", "time", "4", "4001"},
+	}
+	if fmt.Sprint(records) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", records, want)
+	}
+}
+
+func TestBadParams(t *testing.T) {
+	h, store, cleanup := newTestHandlers(t, 3)
+	defer cleanup()
+	for _, url := range []string{
+		"/test/api/results?format=xml",
+		"/test/api/results?limit=0",
+		"/test/api/results?limit=many",
+		"/test/api/results?offset=-1",
+		"/test/api/results?procs=x",
+		"/test/api/results?from=nosuchrev",
+		"/test/api/rev?id=HEAD&format=xml",
+		"/test/api/rev?id=HEAD&limit=0",
+		"/test/api/compare?a=HEAD&format=xml",
+	} {
+		handler := h.handleResults
+		switch {
+		case strings.Contains(url, "/rev"):
+			handler = h.handleRev
+		case strings.Contains(url, "/compare"):
+			handler = h.handleCompare
+		}
+		w := get(handler, url)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v: status %v, want %v", url, w.Code, http.StatusBadRequest)
+		}
+		if w.Header().Get("Link") != "" {
+			t.Errorf("%v: Link header is set on error", url)
+		}
+	}
+	if store.queries != 0 {
+		t.Errorf("store was queried %v times for bad requests", store.queries)
+	}
+}
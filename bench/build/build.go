@@ -25,14 +25,13 @@ func benchmark() driver.Result {
 	if os.Getenv("GOMAXPROCS") == "" {
 		os.Setenv("GOMAXPROCS", "1")
 	}
-	res := driver.MakeResult()
+	var runs []driver.Result
 	for i := 0; i < driver.BenchNum; i++ {
 		res1 := benchmarkOnce()
-		if res.RunTime == 0 || res.RunTime > res1.RunTime {
-			res = res1
-		}
-		log.Printf("Run %v: %+v\n", i, res)
+		runs = append(runs, res1)
+		log.Printf("Run %v: %+v\n", i, res1)
 	}
+	res := driver.Aggregate(runs)
 	gobin := "go"
 	if runtime.GOOS == "windows" {
 		gobin += ".exe"
@@ -10,11 +10,20 @@
 // 2. Call Benchmark helper function and provide benchmarking function
 // func(N uint64), similar to standard testing benchmarks. The rest is handled
 // by the driver.
+//
+// Results are printed as GOPERF-PARAM:axis=value, GOPERF-METRIC:name=value
+// and GOPERF-FILE:name=path lines, or as a single JSON-encoded Output document
+// with the -json flag. Several benchmarks can be selected at once, then every
+// benchmark runs in a child process and the results are printed together
+// (see Report).
+// Benchmark reports medians over BenchNum runs; distribution statistics
+// and raw samples of every metric are available in the JSON document.
 
 package driver
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -31,7 +40,7 @@ import (
 )
 
 var (
-	bench     = flag.String("bench", "", "benchmark to run")
+	bench     = flag.String("bench", "", "comma-separated list of benchmarks to run, optionally with parameters or patterns, e.g. http/keepalive=false,json or *")
 	flake     = flag.Int("flake", 0, "test flakiness of a benchmark")
 	benchNum  = flag.Int("benchnum", 5, "number of benchmark runs")
 	benchMem  = flag.Int("benchmem", 64, "approx RSS value to aim at in benchmarks, in MB")
@@ -39,17 +48,20 @@ var (
 	affinity  = flag.Int("affinity", 0, "process affinity (passed to an OS-specific function like sched_setaffinity/SetProcessAffinityMask)")
 	tmpDir    = flag.String("tmpdir", os.TempDir(), "dir for temporary files")
 	genSvg    = flag.Bool("svg", false, "generate svg profiles")
+	jsonOut   = flag.Bool("json", false, "print results as a JSON document instead of GOPERF lines")
+	best      = flag.Bool("best", false, "report the fastest run instead of medians over all runs")
 
 	BenchNum  int
 	BenchMem  int
 	BenchTime time.Duration
 	WorkDir   string
 
-	benchmarks = make(map[string]func() Result)
+	benchmarks = make(map[string]*benchmark)
 )
 
+// Register registers a benchmark without parameters, see RegisterParams.
 func Register(name string, f func() Result) {
-	benchmarks[name] = f
+	RegisterParams(name, nil, func(Params) Result { return f() })
 }
 
 func Main() {
@@ -68,11 +80,26 @@ func Main() {
 		printBenchmarks()
 		return
 	}
-	f := benchmarks[*bench]
-	if f == nil {
-		fmt.Printf("unknown benchmark '%v'\n", *bench)
+	specs, err := selectBenchmarks(*bench)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	if len(specs) > 1 {
+		if *flake > 0 {
+			fmt.Printf("-flake requires a single benchmark\n")
+			os.Exit(1)
+		}
+		runChildren(specs)
+		return
+	}
+	b, params, err := lookup(specs[0])
+	if err != nil {
+		fmt.Printf("%v\n", err)
 		os.Exit(1)
 	}
+	name := b.name(strings.Split(specs[0], "/")[0], params)
+	f := func() Result { return b.f(params) }
 
 	setupWatchdog()
 
@@ -81,21 +108,103 @@ func Main() {
 		return
 	}
 
-	res := f()
+	out := makeOutput(name, params, f())
+	if *jsonOut {
+		printJSON(out)
+	} else {
+		printLines(out)
+	}
+}
 
+// printLines prints the results as GOPERF lines.
+func printLines(out *Output) {
+	var params []string
+	for k, v := range out.Params {
+		params = append(params, k+"="+v)
+	}
+	sort.Strings(params)
+	for _, p := range params {
+		fmt.Printf("GOPERF-PARAM:%v\n", p)
+	}
 	var metrics []string
-	for k := range res.Metrics {
+	for k := range out.Metrics {
 		metrics = append(metrics, k)
 	}
 	sort.Strings(metrics)
 	for _, m := range metrics {
-		fmt.Printf("GOPERF-METRIC:%v=%v\n", m, res.Metrics[m])
+		fmt.Printf("GOPERF-METRIC:%v=%v\n", m, out.Metrics[m])
 	}
-	for k, v := range res.Files {
+	for k, v := range out.Files {
 		fmt.Printf("GOPERF-FILE:%v=%v\n", k, v)
 	}
 }
 
+// Output is the document printed in -json mode.
+type Output struct {
+	Benchmark string            // name with non-default parameters, e.g. http/body=64k
+	Params    map[string]string // values of all parameter axes
+	Metrics   map[string]uint64 // reported metrics, see Aggregate
+	Stats     map[string]*Stats // distribution of every metric over the runs
+	Files     map[string]string // artifact name -> file name
+	Runs      []Run             // all measured runs
+	Flags     map[string]string // values of all flags, including defaults
+	Env       Env
+	Error     string // set if the benchmark has failed, only in a Report
+}
+
+// Env describes the environment the benchmark ran in.
+type Env struct {
+	GOOS       string
+	GOARCH     string
+	Version    string // runtime.Version
+	Compiler   string
+	NumCPU     int
+	GOMAXPROCS int
+	Hostname   string
+	Vars       map[string]string // GO* environment variables, e.g. GOGC
+}
+
+func makeOutput(name string, params Params, res Result) *Output {
+	out := &Output{
+		Benchmark: name,
+		Params:    params,
+		Metrics:   res.Metrics,
+		Stats:     res.Stats,
+		Files:     res.Files,
+		Runs:      res.Runs,
+		Flags:     make(map[string]string),
+		Env: Env{
+			GOOS:       runtime.GOOS,
+			GOARCH:     runtime.GOARCH,
+			Version:    runtime.Version(),
+			Compiler:   runtime.Compiler,
+			NumCPU:     runtime.NumCPU(),
+			GOMAXPROCS: runtime.GOMAXPROCS(0),
+			Vars:       make(map[string]string),
+		},
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		out.Flags[f.Name] = f.Value.String()
+	})
+	out.Env.Hostname, _ = os.Hostname()
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "GO") {
+			if i := strings.Index(kv, "="); i != -1 {
+				out.Env.Vars[kv[:i]] = kv[i+1:]
+			}
+		}
+	}
+	return out
+}
+
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		log.Fatalf("Failed to marshal results: %v", err)
+	}
+	os.Stdout.Write(append(data, '\n'))
+}
+
 func printBenchmarks() {
 	var bb []string
 	for name, _ := range benchmarks {
@@ -152,37 +261,50 @@ type Result struct {
 	RunTime  uint64        // ns/op
 	Metrics  map[string]uint64
 	Files    map[string]string
+	Runs     []Run             // all measured runs, see Aggregate
+	Stats    map[string]*Stats // set by Aggregate
+}
+
+// Run is a single measured run of a benchmark.
+type Run struct {
+	N        uint64
+	Duration time.Duration
+	Metrics  map[string]uint64
 }
 
 func MakeResult() Result {
 	return Result{Metrics: make(map[string]uint64), Files: make(map[string]string)}
 }
 
-// Benchmark runs f several times, collects stats, chooses the best run
-// and creates cpu/mem profiles.
+// MakeRun records the measurements of res as a Run.
+func MakeRun(res Result) Run {
+	run := Run{N: res.N, Duration: res.Duration, Metrics: make(map[string]uint64)}
+	for k, v := range res.Metrics {
+		run.Metrics[k] = v
+	}
+	return run
+}
+
+// Benchmark runs f several times, collects stats, aggregates the runs
+// (see Aggregate) and creates cpu/mem profiles.
 func Benchmark(f func(uint64)) Result {
-	res := MakeResult()
+	var runs []Result
 	for i := 0; i < *benchNum; i++ {
-		res1 := runBenchmark(f)
-		if res.N == 0 || res.RunTime > res1.RunTime {
-			res = res1
-		}
-		// Always take RSS and sys memory metrics from last iteration.
-		// They only grow, and seem to converge to some eigen value.
-		// Variations are smaller if we do this.
-		for k, v := range res1.Metrics {
-			if k == "rss" || strings.HasPrefix(k, "sys-") {
-				res.Metrics[k] = v
-			}
-		}
+		runs = append(runs, runBenchmark(f))
 	}
+	res := Aggregate(runs)
 
+	// Raw profiles are kept along with the rendered ones,
+	// so that goperfd can compare profiles of different revisions.
+	res.Files["cpuprof-raw"] = res.Files["cpuprof"]
 	cpuprof := processProfile(os.Args[0], res.Files["cpuprof"])
 	delete(res.Files, "cpuprof")
 	if cpuprof != "" {
 		res.Files["cpuprof"] = cpuprof
 	}
 
+	res.Files["memprof-raw"] = res.Files["memprof"]
+	res.Files["memprof0-raw"] = res.Files["memprof0"]
 	memprof := processProfile("--lines", "--show_bytes", "--alloc_space", "--base", res.Files["memprof0"], os.Args[0], res.Files["memprof"])
 	delete(res.Files, "memprof")
 	delete(res.Files, "memprof0")
@@ -235,7 +357,7 @@ func runBenchmark(f func(uint64)) Result {
 
 // runBenchmarkOnce runs f once and collects all performance metrics and profiles.
 func runBenchmarkOnce(f func(uint64), N uint64) Result {
-	latencyInit(N)
+	latencyInit()
 	runtime.GC()
 	mstats0 := new(runtime.MemStats)
 	runtime.ReadMemStats(mstats0)
@@ -309,50 +431,6 @@ func Parallel(N uint64, P int, f func()) {
 	wg.Wait()
 }
 
-// perfLatency collects and reports information about latencies.
-var latency struct {
-	data latencyData
-	idx  int32
-}
-
-type latencyData []uint64
-
-func (p latencyData) Len() int           { return len(p) }
-func (p latencyData) Less(i, j int) bool { return p[i] < p[j] }
-func (p latencyData) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
-
-func latencyInit(N uint64) {
-	N = min(N, 1e6) // bound the amount of memory consumed
-	latency.data = make(latencyData, N)
-	latency.idx = 0
-}
-
-func LatencyNote(t time.Time) {
-	d := time.Since(t)
-	if int(atomic.LoadInt32(&latency.idx)) >= len(latency.data) {
-		return
-	}
-	i := atomic.AddInt32(&latency.idx, 1) - 1
-	if int(i) >= len(latency.data) {
-		return
-	}
-	latency.data[i] = uint64(d)
-}
-
-func latencyCollect(res *Result) {
-	cnt := int(latency.idx)
-	if cnt == 0 {
-		return
-	}
-	if cnt > len(latency.data) {
-		cnt = len(latency.data)
-	}
-	sort.Sort(latency.data[:cnt])
-	res.Metrics["latency-50"] = latency.data[cnt*50/100]
-	res.Metrics["latency-95"] = latency.data[cnt*95/100]
-	res.Metrics["latency-99"] = latency.data[cnt*99/100]
-}
-
 // chooseN chooses the next number of iterations for benchmark.
 func chooseN(res *Result) bool {
 	const MaxN = 1e12
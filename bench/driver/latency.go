@@ -0,0 +1,145 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Latencies are recorded in a log-linear histogram: values below 2^latSubBits
+// are recorded exactly, larger values fall into buckets that split every
+// power of two into 2^latSubBits parts, so the relative error is below 1%.
+// The histogram takes fixed memory and recording does not take locks.
+const (
+	latSubBits  = 7
+	latSubCount = 1 << latSubBits
+	latBuckets  = (64 - latSubBits + 1) * latSubCount
+)
+
+// latency collects latencies of the current run.
+var latency struct {
+	counts [latBuckets]uint64
+	max    uint64
+}
+
+// latencyQuantiles are the reported quantiles and the names of the metrics.
+var latencyQuantiles = []struct {
+	q    float64
+	name string
+}{
+	{0.5, "latency-50"},
+	{0.9, "latency-90"},
+	{0.95, "latency-95"},
+	{0.99, "latency-99"},
+	{0.999, "latency-99.9"},
+	{0.9999, "latency-99.99"},
+}
+
+func latencyInit() {
+	for i := range latency.counts {
+		latency.counts[i] = 0
+	}
+	latency.max = 0
+}
+
+// LatencyNote records latency of an operation that has started at t.
+// It can be called concurrently.
+func LatencyNote(t time.Time) {
+	d := uint64(time.Since(t))
+	atomic.AddUint64(&latency.counts[latBucket(d)], 1)
+	for {
+		max := atomic.LoadUint64(&latency.max)
+		if d <= max || atomic.CompareAndSwapUint64(&latency.max, max, d) {
+			break
+		}
+	}
+}
+
+// latBucket returns index of the bucket for the value.
+func latBucket(v uint64) int {
+	if v < latSubCount {
+		return int(v)
+	}
+	shift := uint(0)
+	for v>>shift >= 2*latSubCount {
+		shift++
+	}
+	return int(shift+1)*latSubCount + int(v>>shift) - latSubCount
+}
+
+// latBucketRange returns the range of values [low, high] of the bucket.
+// high is inclusive, because the range of the last bucket ends at MaxUint64.
+func latBucketRange(i int) (low, high uint64) {
+	if i < latSubCount {
+		return uint64(i), uint64(i)
+	}
+	shift := uint(i/latSubCount - 1)
+	low = uint64(i%latSubCount+latSubCount) << shift
+	return low, low + (1<<shift - 1)
+}
+
+func latencyCollect(res *Result) {
+	var total uint64
+	for _, c := range latency.counts {
+		total += c
+	}
+	if total == 0 {
+		return
+	}
+	for _, lq := range latencyQuantiles {
+		rank := uint64(math.Ceil(lq.q * float64(total)))
+		var sum uint64
+		for i, c := range latency.counts {
+			sum += c
+			if sum >= rank {
+				low, high := latBucketRange(i)
+				v := low + (high-low)/2
+				if v > latency.max {
+					v = latency.max
+				}
+				res.Metrics[lq.name] = v
+				break
+			}
+		}
+	}
+	res.Metrics["latency-max"] = latency.max
+	if f := writeLatencyHistogram(total); f != "" {
+		res.Files["latency"] = f
+	}
+}
+
+// writeLatencyHistogram writes non-empty buckets of the histogram
+// to a file and returns its name, or an empty string on failure.
+func writeLatencyHistogram(total uint64) string {
+	f, err := os.Create(tempFilename("latency.txt"))
+	if err != nil {
+		log.Printf("Failed to create latency histogram file: %v", err)
+		return ""
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "# latency histogram: %v samples, max %v ns\n", total, latency.max)
+	fmt.Fprintf(w, "# from-ns to-ns (inclusive) count cumulative-fraction\n")
+	var sum uint64
+	for i, c := range latency.counts {
+		if c == 0 {
+			continue
+		}
+		sum += c
+		low, high := latBucketRange(i)
+		fmt.Fprintf(w, "%v %v %v %.6f\n", low, high, c, float64(sum)/float64(total))
+	}
+	if err := w.Flush(); err != nil {
+		log.Printf("Failed to write latency histogram: %v", err)
+		return ""
+	}
+	return f.Name()
+}
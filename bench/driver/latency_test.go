@@ -0,0 +1,120 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+)
+
+func TestLatBucket(t *testing.T) {
+	values := []uint64{0, 1, 127, 128, 129, 255, 256, 257, 511, 512, 1000, 1e6, 1e9, 1<<63 - 1, 1 << 63, math.MaxUint64 - 1, math.MaxUint64}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		values = append(values, uint64(r.Int63())>>uint(r.Intn(64)))
+	}
+	for _, v := range values {
+		i := latBucket(v)
+		if i < 0 || i >= latBuckets {
+			t.Errorf("value %v: bucket %v is out of range", v, i)
+			continue
+		}
+		low, high := latBucketRange(i)
+		if v < low || v > high {
+			t.Errorf("value %v: bucket %v has range [%v, %v]", v, i, low, high)
+		}
+		// The resolution promised by the doc comment.
+		if v >= latSubCount && float64(high-low) > float64(low)/latSubCount {
+			t.Errorf("value %v: bucket [%v, %v] is too wide", v, low, high)
+		}
+	}
+	if i := latBucket(math.MaxUint64); i != latBuckets-1 {
+		t.Errorf("MaxUint64 is in bucket %v, want the last one %v", i, latBuckets-1)
+	}
+	// Buckets are contiguous.
+	for i := 1; i < latBuckets; i++ {
+		_, prevHigh := latBucketRange(i - 1)
+		low, high := latBucketRange(i)
+		if low != prevHigh+1 || high < low || latBucket(low) != i || latBucket(high) != i {
+			t.Fatalf("bucket %v: [%v, %v] after bucket ending at %v", i, low, high, prevHigh)
+		}
+	}
+	if _, high := latBucketRange(latBuckets - 1); high != math.MaxUint64 {
+		t.Errorf("last bucket ends at %v", high)
+	}
+}
+
+func TestLatencyQuantiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goperf-latency-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { *tmpDir = d }(*tmpDir)
+	*tmpDir = dir
+
+	r := rand.New(rand.NewSource(1))
+	uniform := make([]uint64, 100000)
+	for i := range uniform {
+		uniform[i] = uint64(i + 1)
+	}
+	exp := make([]uint64, 100000)
+	for i := range exp {
+		exp[i] = uint64(1e5*r.ExpFloat64()) + 1
+	}
+	// Most operations are fast, a few are stalled by GC.
+	bimodal := make([]uint64, 100000)
+	for i := range bimodal {
+		bimodal[i] = uint64(2e4 + r.Intn(1e3))
+		if i%500 == 0 {
+			bimodal[i] = uint64(5e7 + r.Intn(1e7))
+		}
+	}
+	for _, c := range []struct {
+		desc   string
+		values []uint64
+	}{
+		{"uniform", uniform},
+		{"exponential", exp},
+		{"bimodal", bimodal},
+		{"small", []uint64{3, 1, 2}},
+	} {
+		latencyInit()
+		for _, v := range c.values {
+			latency.counts[latBucket(v)]++
+			if v > latency.max {
+				latency.max = v
+			}
+		}
+		res := MakeResult()
+		latencyCollect(&res)
+		sorted := append([]uint64(nil), c.values...)
+		sort.Sort(uint64Slice(sorted))
+		for _, lq := range latencyQuantiles {
+			want := sorted[int(math.Ceil(lq.q*float64(len(sorted))))-1]
+			got := res.Metrics[lq.name]
+			if math.Abs(float64(got)-float64(want)) > 0.01*float64(want) {
+				t.Errorf("%v: %v is %v, want %v within 1%%", c.desc, lq.name, got, want)
+			}
+		}
+		if got, want := res.Metrics["latency-max"], sorted[len(sorted)-1]; got != want {
+			t.Errorf("%v: latency-max is %v, want %v", c.desc, got, want)
+		}
+		if res.Files["latency"] == "" {
+			t.Errorf("%v: no histogram file", c.desc)
+		}
+	}
+	latencyInit()
+}
+
+type uint64Slice []uint64
+
+func (p uint64Slice) Len() int           { return len(p) }
+func (p uint64Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p uint64Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,141 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Report is the document printed in -json mode when several benchmarks are selected.
+// In the line format results of every benchmark are preceded by a GOPERF-BENCH:name
+// line, a failed benchmark is reported with a GOPERF-ERROR:message line instead.
+type Report struct {
+	Benchmarks []*Output
+}
+
+// selectBenchmarks expands the -bench flag into a list of benchmarks.
+// Names can be patterns (path.Match syntax), parameters apply to
+// every benchmark that matches, e.g. "*/scale=10" is allowed as long as
+// all matched benchmarks have the scale parameter.
+func selectBenchmarks(list string) ([]string, error) {
+	var names []string
+	for name := range benchmarks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var specs []string
+	for _, spec := range strings.Split(list, ",") {
+		if spec == "" {
+			continue
+		}
+		base, params := spec, ""
+		if i := strings.Index(spec, "/"); i != -1 {
+			base, params = spec[:i], spec[i:]
+		}
+		matched := false
+		for _, name := range names {
+			if ok, err := path.Match(base, name); err != nil {
+				return nil, fmt.Errorf("bad benchmark pattern '%v'", base)
+			} else if ok {
+				specs = append(specs, name+params)
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("unknown benchmark '%v'", base)
+		}
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no benchmarks selected")
+	}
+	for _, spec := range specs {
+		if _, _, err := lookup(spec); err != nil {
+			return nil, err
+		}
+	}
+	return specs, nil
+}
+
+// runChildren runs every benchmark in a fresh child process, so that
+// package-level state of one benchmark (servers, caches, heap) does not affect
+// others, and prints the results together. A failed benchmark does not stop
+// the rest, but the process exits with non-zero status in the end.
+func runChildren(specs []string) {
+	rep := new(Report)
+	failed := false
+	for _, spec := range specs {
+		// Every child gets its own temp dir, because temp file names are only unique
+		// within a process. The dir is unique, so concurrent runs and leftovers
+		// of previous runs do not overwrite files of the child. It is not removed,
+		// the files are reported to the caller.
+		var out *Output
+		dir, err := ioutil.TempDir(*tmpDir, "bench")
+		if err == nil {
+			out, err = runChild(spec, dir)
+		}
+		if err != nil {
+			log.Printf("Benchmark %v failed: %v", spec, err)
+			out = &Output{Benchmark: spec, Error: err.Error()}
+			failed = true
+		}
+		rep.Benchmarks = append(rep.Benchmarks, out)
+	}
+	if *jsonOut {
+		printJSON(rep)
+	} else {
+		for _, out := range rep.Benchmarks {
+			fmt.Printf("GOPERF-BENCH:%v\n", out.Benchmark)
+			if out.Error != "" {
+				fmt.Printf("GOPERF-ERROR:%v\n", firstLine(out.Error))
+				continue
+			}
+			printLines(out)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// runChild re-executes the binary for a single benchmark with the same flags.
+func runChild(spec, dir string) (*Output, error) {
+	args := []string{"-bench=" + spec, "-json", "-tmpdir=" + dir}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "bench", "json", "tmpdir":
+		default:
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	var stdout bytes.Buffer
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, stdout.Bytes())
+	}
+	out := new(Output)
+	if err := json.Unmarshal(stdout.Bytes(), out); err != nil {
+		return nil, fmt.Errorf("failed to parse output: %v\n%s", err, stdout.Bytes())
+	}
+	return out, nil
+}
+
+func firstLine(s string) string {
+	if i := strings.Index(s, "\n"); i != -1 {
+		return s[:i]
+	}
+	return s
+}
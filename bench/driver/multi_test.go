@@ -0,0 +1,66 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"reflect"
+	"testing"
+)
+
+// withBenchmarks replaces registered benchmarks for the duration of a test.
+func withBenchmarks() func() {
+	saved := benchmarks
+	benchmarks = make(map[string]*benchmark)
+	f := func() Result { return MakeResult() }
+	Register("json", f)
+	Register("garbage", f)
+	RegisterParams("http", []Param{{"keepalive", []string{"true", "false"}}, {"body", []string{"13", "64k"}}},
+		func(Params) Result { return MakeResult() })
+	RegisterParams("httpmux", []Param{{"body", []string{"13"}}},
+		func(Params) Result { return MakeResult() })
+	return func() { benchmarks = saved }
+}
+
+func TestSelectBenchmarks(t *testing.T) {
+	defer withBenchmarks()()
+	for _, c := range []struct {
+		list string
+		want []string
+	}{
+		{"json", []string{"json"}},
+		{"json,garbage", []string{"json", "garbage"}},
+		{"garbage,json,", []string{"garbage", "json"}},
+		{"*", []string{"garbage", "http", "httpmux", "json"}},
+		{"http*", []string{"http", "httpmux"}},
+		{"http/keepalive=false", []string{"http/keepalive=false"}},
+		// Parameters apply to every match.
+		{"http*/body=1k", []string{"http/body=1k", "httpmux/body=1k"}},
+		{"?son,http/body=64k/keepalive=false", []string{"json", "http/body=64k/keepalive=false"}},
+	} {
+		specs, err := selectBenchmarks(c.list)
+		if err != nil {
+			t.Errorf("%q: %v", c.list, err)
+			continue
+		}
+		if !reflect.DeepEqual(specs, c.want) {
+			t.Errorf("%q: got %q, want %q", c.list, specs, c.want)
+		}
+	}
+	for _, list := range []string{
+		"",
+		",",
+		"nosuch",
+		"json,nosuch",
+		"[",
+		"http/nosuch=1",
+		"http/keepalive",
+		// json has no body parameter.
+		"*/body=1k",
+	} {
+		if specs, err := selectBenchmarks(list); err == nil {
+			t.Errorf("%q: got %q, want an error", list, specs)
+		}
+	}
+}
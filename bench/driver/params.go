@@ -0,0 +1,113 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Param is a parameter axis of a benchmark.
+type Param struct {
+	Name   string
+	Values []string // notable values of the axis, the first one is the default
+}
+
+// Params are values of all parameter axes of a benchmark keyed by axis name.
+type Params map[string]string
+
+type benchmark struct {
+	params []Param
+	f      func(Params) Result
+}
+
+// RegisterParams registers a benchmark with parameter axes.
+// The benchmark is selected with -bench=name/axis=value/axis=value,
+// axes that are not mentioned take their default values. A value
+// does not have to be one of Param.Values, it is checked by the benchmark.
+func RegisterParams(name string, params []Param, f func(Params) Result) {
+	for _, p := range params {
+		if p.Name == "" || strings.ContainsAny(p.Name, "/=") || len(p.Values) == 0 {
+			panic(fmt.Sprintf("bad parameter '%v' of benchmark '%v'", p.Name, name))
+		}
+	}
+	benchmarks[name] = &benchmark{params, f}
+}
+
+// lookup parses the -bench flag, e.g. http/keepalive=false/body=64k.
+// It returns the benchmark with values of all its axes.
+func lookup(spec string) (*benchmark, Params, error) {
+	parts := strings.Split(spec, "/")
+	b := benchmarks[parts[0]]
+	if b == nil {
+		return nil, nil, fmt.Errorf("unknown benchmark '%v'", parts[0])
+	}
+	params := make(Params)
+	for _, p := range b.params {
+		params[p.Name] = p.Values[0]
+	}
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, nil, fmt.Errorf("bad parameter '%v', want axis=value", part)
+		}
+		if _, ok := params[kv[0]]; !ok {
+			return nil, nil, fmt.Errorf("benchmark '%v' has no parameter '%v'", parts[0], kv[0])
+		}
+		params[kv[0]] = kv[1]
+	}
+	return b, params, nil
+}
+
+// name returns the canonical name of the benchmark run: the axes that do not
+// have their default values, in the order of axes, e.g. http/body=64k.
+// The run with all defaults has the bare name, so that adding parameters
+// to a benchmark does not break its series of results.
+func (b *benchmark) name(base string, params Params) string {
+	name := base
+	for _, p := range b.params {
+		if v := params[p.Name]; v != p.Values[0] {
+			name += "/" + p.Name + "=" + v
+		}
+	}
+	return name
+}
+
+// Bool returns the value of a boolean axis.
+func (p Params) Bool(name string) bool {
+	v, err := strconv.ParseBool(p[name])
+	if err != nil {
+		log.Fatalf("bad value of parameter %v: '%v'", name, p[name])
+	}
+	return v
+}
+
+// Int returns the value of an integer axis.
+func (p Params) Int(name string) int {
+	v, err := strconv.Atoi(p[name])
+	if err != nil {
+		log.Fatalf("bad value of parameter %v: '%v'", name, p[name])
+	}
+	return v
+}
+
+// Size returns the value of a size axis, e.g. 512, 64k or 1m.
+func (p Params) Size(name string) int {
+	s := strings.ToLower(p[name])
+	mult := 1
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult, s = 1<<10, s[:len(s)-1]
+	case strings.HasSuffix(s, "m"):
+		mult, s = 1<<20, s[:len(s)-1]
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		log.Fatalf("bad value of parameter %v: '%v'", name, p[name])
+	}
+	return v * mult
+}
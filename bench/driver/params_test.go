@@ -0,0 +1,90 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	defer withBenchmarks()()
+	for _, c := range []struct {
+		spec   string
+		params Params
+		name   string
+	}{
+		{"json", Params{}, "json"},
+		// The default point has the bare name, so that the series continue.
+		{"http", Params{"keepalive": "true", "body": "13"}, "http"},
+		{"http/keepalive=true/body=13", Params{"keepalive": "true", "body": "13"}, "http"},
+		{"http/keepalive=false", Params{"keepalive": "false", "body": "13"}, "http/keepalive=false"},
+		{"http/body=64k", Params{"keepalive": "true", "body": "64k"}, "http/body=64k"},
+		// Values outside of Param.Values are allowed, axes are named in their order.
+		{"http/body=1m/keepalive=false", Params{"keepalive": "false", "body": "1m"}, "http/keepalive=false/body=1m"},
+		{"http/body=1k/body=2k", Params{"keepalive": "true", "body": "2k"}, "http/body=2k"},
+		{"http/body=", Params{"keepalive": "true", "body": ""}, "http/body="},
+	} {
+		b, params, err := lookup(c.spec)
+		if err != nil {
+			t.Errorf("%v: %v", c.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(params, c.params) {
+			t.Errorf("%v: got params %v, want %v", c.spec, params, c.params)
+		}
+		if name := b.name(strings.Split(c.spec, "/")[0], params); name != c.name {
+			t.Errorf("%v: got name %v, want %v", c.spec, name, c.name)
+		}
+	}
+	for _, spec := range []string{
+		"",
+		"nosuch",
+		"json/body=13",
+		"http/nosuch=1",
+		"http/keepalive",
+		"http/=1",
+		"http//body=13",
+	} {
+		if _, _, err := lookup(spec); err == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+}
+
+func TestRegisterBadParams(t *testing.T) {
+	defer withBenchmarks()()
+	for _, p := range []Param{
+		{"", []string{"1"}},
+		{"a/b", []string{"1"}},
+		{"a=b", []string{"1"}},
+		{"n", nil},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("parameter %+v accepted", p)
+				}
+			}()
+			RegisterParams("bad", []Param{p}, nil)
+		}()
+	}
+}
+
+func TestParamValues(t *testing.T) {
+	p := Params{"keepalive": "false", "procs": "8", "small": "512", "k": "64k", "K": "2K", "m": "1m", "zero": "0"}
+	if p.Bool("keepalive") {
+		t.Errorf("keepalive is true")
+	}
+	if v := p.Int("procs"); v != 8 {
+		t.Errorf("procs is %v", v)
+	}
+	for name, want := range map[string]int{"small": 512, "k": 64 << 10, "K": 2 << 10, "m": 1 << 20, "zero": 0, "procs": 8} {
+		if v := p.Size(name); v != want {
+			t.Errorf("size %v is %v, want %v", name, v, want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Stats summarizes values of a metric over all runs of a benchmark.
+type Stats struct {
+	Median  float64
+	Mean    float64
+	Stddev  float64 // sample standard deviation
+	Min     uint64
+	Max     uint64
+	CILow   float64 // 95% confidence interval of the mean
+	CIHigh  float64
+	Samples []uint64 // in run order
+}
+
+// Aggregate combines results of several runs of a benchmark.
+// Metrics of the combined result are medians over the runs and files come
+// from the run closest to the median time. With -best, metrics and files
+// come from the fastest run, except for rss and sys-* metrics that are taken
+// from the last run. Stats and Runs are set in both cases.
+func Aggregate(runs []Result) Result {
+	if len(runs) == 0 {
+		return MakeResult()
+	}
+	if *best {
+		return aggregateBest(runs)
+	}
+	res := MakeResult()
+	res.Stats = make(map[string]*Stats)
+	for _, r := range runs {
+		res.Runs = append(res.Runs, MakeRun(r))
+		for k := range r.Metrics {
+			if res.Stats[k] == nil {
+				res.Stats[k] = makeStats(runs, k)
+			}
+		}
+	}
+	for k, st := range res.Stats {
+		res.Metrics[k] = uint64(st.Median + 0.5)
+	}
+	times := make([]float64, len(runs))
+	for i, r := range runs {
+		times[i] = float64(r.RunTime)
+	}
+	median := medianOf(times)
+	var closest Result
+	for i, r := range runs {
+		if i == 0 || math.Abs(float64(r.RunTime)-median) < math.Abs(float64(closest.RunTime)-median) {
+			closest = r
+		}
+	}
+	res.N = closest.N
+	res.Duration = closest.Duration
+	res.RunTime = closest.RunTime
+	res.Files = closest.Files
+	return res
+}
+
+// aggregateBest implements the -best mode of Aggregate.
+func aggregateBest(runs []Result) Result {
+	res := MakeResult()
+	var all []Run
+	for i, r := range runs {
+		all = append(all, MakeRun(r))
+		if i == 0 || res.RunTime > r.RunTime {
+			// Copy the metrics, they are overwritten below
+			// and r must stay intact for Runs and Stats.
+			res = r
+			res.Metrics = MakeRun(r).Metrics
+		}
+		// Always take RSS and sys memory metrics from last iteration.
+		// They only grow, and seem to converge to some eigen value.
+		// Variations are smaller if we do this.
+		for k, v := range r.Metrics {
+			if k == "rss" || strings.HasPrefix(k, "sys-") {
+				res.Metrics[k] = v
+			}
+		}
+	}
+	res.Runs = all
+	res.Stats = make(map[string]*Stats)
+	for k := range res.Metrics {
+		res.Stats[k] = makeStats(runs, k)
+	}
+	return res
+}
+
+func makeStats(runs []Result, metric string) *Stats {
+	st := new(Stats)
+	var values []float64
+	for _, r := range runs {
+		v, ok := r.Metrics[metric]
+		if !ok {
+			continue
+		}
+		if len(st.Samples) == 0 || v < st.Min {
+			st.Min = v
+		}
+		if len(st.Samples) == 0 || v > st.Max {
+			st.Max = v
+		}
+		st.Samples = append(st.Samples, v)
+		values = append(values, float64(v))
+		st.Mean += float64(v)
+	}
+	n := float64(len(values))
+	st.Mean /= n
+	st.Median = medianOf(values)
+	st.CILow, st.CIHigh = st.Mean, st.Mean
+	if len(values) < 2 {
+		return st
+	}
+	for _, v := range values {
+		st.Stddev += (v - st.Mean) * (v - st.Mean)
+	}
+	st.Stddev = math.Sqrt(st.Stddev / (n - 1))
+	d := studentT95(len(values)-1) * st.Stddev / math.Sqrt(n)
+	st.CILow, st.CIHigh = st.Mean-d, st.Mean+d
+	return st
+}
+
+func medianOf(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// tTable contains two-sided 95% quantiles of Student's t-distribution
+// for 1 to 30 degrees of freedom.
+var tTable = [...]float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+func studentT95(df int) float64 {
+	if df <= len(tTable) {
+		return tTable[df-1]
+	}
+	return 1.96
+}
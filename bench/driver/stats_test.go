@@ -0,0 +1,50 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"testing"
+)
+
+func testRun(runTime, rss uint64) Result {
+	res := MakeResult()
+	res.RunTime = runTime
+	res.Metrics["time"] = runTime
+	res.Metrics["rss"] = rss
+	return res
+}
+
+func TestAggregateBest(t *testing.T) {
+	*best = true
+	defer func() { *best = false }()
+	runs := []Result{testRun(10, 100), testRun(5, 200), testRun(7, 300)}
+	res := Aggregate(runs)
+	if res.Metrics["time"] != 5 || res.Metrics["rss"] != 300 {
+		t.Fatalf("got time=%v rss=%v, want time=5 rss=300", res.Metrics["time"], res.Metrics["rss"])
+	}
+	for i, want := range []uint64{100, 200, 300} {
+		if v := runs[i].Metrics["rss"]; v != want {
+			t.Errorf("run %v: rss changed to %v, want %v", i, v, want)
+		}
+		if v := res.Runs[i].Metrics["rss"]; v != want {
+			t.Errorf("Runs[%v]: rss=%v, want %v", i, v, want)
+		}
+	}
+	st := res.Stats["rss"]
+	if st.Min != 100 || st.Max != 300 || st.Median != 200 {
+		t.Errorf("bad rss stats: %+v", st)
+	}
+}
+
+func TestAggregateMedian(t *testing.T) {
+	runs := []Result{testRun(10, 100), testRun(5, 200), testRun(7, 300)}
+	res := Aggregate(runs)
+	if res.Metrics["time"] != 7 || res.Metrics["rss"] != 200 {
+		t.Fatalf("got time=%v rss=%v, want time=7 rss=200", res.Metrics["time"], res.Metrics["rss"])
+	}
+	if res.RunTime != 7 || len(res.Runs) != 3 {
+		t.Fatalf("got RunTime=%v and %v runs, want 7 and 3", res.RunTime, len(res.Runs))
+	}
+}
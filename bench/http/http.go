@@ -5,7 +5,7 @@
 package http
 
 import (
-	"fmt"
+	"bytes"
 	"io/ioutil"
 	"log"
 	"net"
@@ -17,10 +17,22 @@ import (
 )
 
 func init() {
-	driver.Register("http", benchmark)
+	driver.RegisterParams("http", []driver.Param{
+		{Name: "keepalive", Values: []string{"true", "false"}},
+		// Size of the response body, the default is len("Hello world.\n").
+		{Name: "body", Values: []string{"13", "1k", "64k"}},
+	}, benchmark)
 }
 
-func benchmark() driver.Result {
+var (
+	keepAlive bool
+	body      []byte
+)
+
+func benchmark(p driver.Params) driver.Result {
+	keepAlive = p.Bool("keepalive")
+	size := p.Size("body")
+	body = bytes.Repeat([]byte("Hello world.\n"), size/13+1)[:size]
 	return driver.Benchmark(benchmarkHttpImpl)
 }
 
@@ -29,6 +41,7 @@ func benchmarkHttpImpl(N uint64) {
 		Transport: &http.Transport{
 			Proxy:               http.ProxyFromEnvironment,
 			MaxIdleConnsPerHost: 4 * runtime.GOMAXPROCS(0),
+			DisableKeepAlives:   !keepAlive,
 		},
 	}
 	driver.Parallel(N, 4, func() {
@@ -44,9 +57,8 @@ func benchmarkHttpImpl(N uint64) {
 			log.Printf("ReadAll: %v", err)
 			return
 		}
-		body := string(all)
-		if body != "Hello world.\n" {
-			log.Fatalf("Got body: " + body)
+		if !bytes.Equal(all, body) {
+			log.Fatalf("Got body: %q", all)
 		}
 		driver.LatencyNote(t0)
 	})
@@ -65,7 +77,7 @@ var server = func() *http.Server {
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			fmt.Fprintf(w, "Hello world.\n")
+			w.Write(body)
 		}),
 	}
 	go s.Serve(l)
@@ -4,6 +4,7 @@
 
 // JSON benchmark marshals and unmarshals ~2MB json string
 // with a tree-like object hierarchy, in 4*GOMAXPROCS goroutines.
+// The scale parameter makes the document that many times larger.
 
 package json
 
@@ -14,25 +15,50 @@ import (
 	"encoding/json"
 	"io"
 	"io/ioutil"
+	"log"
 
 	"code.google.com/p/goperfd/bench/driver"
 )
 
 func init() {
-	driver.Register("json", benchmark)
+	driver.RegisterParams("json", []driver.Param{
+		// The document consists of that many copies of the original tree.
+		{Name: "scale", Values: []string{"1", "10"}},
+	}, benchmark)
 }
 
-func benchmark() driver.Result {
+var (
+	scaledbytes []byte
+	scaleddata  Response
+)
+
+func benchmark(p driver.Params) driver.Result {
+	scale := p.Int("scale")
+	if scale < 1 {
+		log.Fatalf("bad scale %v", scale)
+	}
+	scaledbytes, scaleddata = jsonbytes, jsondata
+	if scale > 1 {
+		root := &Node{Name: "root"}
+		for i := 0; i < scale; i++ {
+			root.Kids = append(root.Kids, jsondata.Tree)
+		}
+		scaleddata = Response{Tree: root, Username: jsondata.Username}
+		var err error
+		if scaledbytes, err = json.Marshal(&scaleddata); err != nil {
+			panic(err)
+		}
+	}
 	return driver.Benchmark(benchmarkN)
 }
 
 func benchmarkN(N uint64) {
 	driver.Parallel(N, 4, func() {
 		var r Response
-		if err := json.Unmarshal(jsonbytes, &r); err != nil {
+		if err := json.Unmarshal(scaledbytes, &r); err != nil {
 			panic(err)
 		}
-		if _, err := json.Marshal(&jsondata); err != nil {
+		if _, err := json.Marshal(&scaleddata); err != nil {
 			panic(err)
 		}
 	})
@@ -9,13 +9,20 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"go/build"
+	"math"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/regress"
 )
 
 var (
@@ -27,8 +34,31 @@ var (
 	affinity  = flag.String("affinity", "", "comma-delimited list of process affinities")
 	oldBin    = flag.String("old", "", "old bench binary")
 	newBin    = flag.String("new", "", "new bench binary")
+	cfgFile   = flag.String("config", "", "project config that describes metrics, configs/go.cfg of goperfd by default")
 )
 
+var cfg = new(config.ProjectConfig)
+
+// defaultConfig is the project config of the bench driver, it describes
+// all metrics the driver reports. It is found in the source tree of goperfd.
+const defaultConfig = "code.google.com/p/goperfd/configs/go.cfg"
+
+// loadConfig loads the config from -config or the default one.
+func loadConfig() error {
+	file := *cfgFile
+	if file == "" {
+		pkg, err := build.Import(path.Dir(defaultConfig), "", build.FindOnly)
+		if err != nil {
+			return fmt.Errorf("failed to find default config, specify -config: %v", err)
+		}
+		file = filepath.Join(pkg.Dir, path.Base(defaultConfig))
+	}
+	if err := config.Load(cfg, file); err != nil {
+		return fmt.Errorf("failed to load config '%v': %v", file, err)
+	}
+	return nil
+}
+
 func main() {
 	flag.Parse()
 	if *oldBin == "" || *newBin == "" {
@@ -36,6 +66,10 @@ func main() {
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
+	if err := loadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 	if *benchList == "" {
 		*benchList = "build,garbage,http,json,rpc,widefinder"
 	}
@@ -43,69 +77,133 @@ func main() {
 		*benchCPU = "1"
 	}
 	affinityList := strings.Split(*affinity, ",")
-	for _, bench := range strings.Split(*benchList, ",") {
-		for pi, procs := range strings.Split(*benchCPU, ",") {
-			aff := ""
-			if len(affinityList) > pi {
-				aff = affinityList[pi]
-			}
-			if aff == "" {
-				aff = "0"
-			}
-			benchCmp(bench, procs, aff)
+	for pi, procs := range strings.Split(*benchCPU, ",") {
+		aff := ""
+		if len(affinityList) > pi {
+			aff = affinityList[pi]
+		}
+		if aff == "" {
+			aff = "0"
+		}
+		// All benchmarks are run by a single invocation of each binary.
+		names, oldRes := benchRun(*oldBin, procs, aff)
+		_, newRes := benchRun(*newBin, procs, aff)
+		for _, bench := range names {
+			benchCmp(bench, procs, oldRes[bench], newRes[bench])
 		}
 	}
 }
 
 type Metrics map[string]uint64
 
-func benchCmp(bench, procs, aff string) {
+func benchCmp(bench, procs string, m0, m1 Metrics) {
 	fmt.Printf("%v-%v\n", bench, procs)
-	m0 := benchOne(*oldBin, bench, procs, aff)
-	m1 := benchOne(*newBin, bench, procs, aff)
-
-	var metrics []string
-	for metric := range m0 {
-		metrics = append(metrics, metric)
+	if m0 == nil || m1 == nil {
+		fmt.Printf("failed\n\n")
+		return
 	}
-	sort.Strings(metrics)
-	for _, metric := range metrics {
-		v0 := m0[metric]
-		v1 := m1[metric]
-		d := float64(v1)/float64(v0)*100 - 100
-		fmt.Printf("%-20s %12v %12v %10v%%\n", metric, v0, v1, fmt.Sprintf("%+.2f", d))
+	var names []string
+	for name := range m0 {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := cfg.Metric(name)
+		v0 := float64(m0[name])
+		v1 := float64(m1[name])
+		d, verdict := compare(m, v0, v1)
+		fmt.Printf("%-20s %12v %12v %11v %v\n", name, m.Format(v0), m.Format(v1), d, verdict)
 	}
 	fmt.Printf("\n")
 }
 
-func benchOne(bin, bench, procs, aff string) Metrics {
+// compare returns the relative change of the metric and the verdict,
+// which is empty if the change is below the threshold of the metric.
+// The relative change from zero is not defined, so it is reported
+// without a verdict, thresholds do not apply to it.
+func compare(m *config.Metric, v0, v1 float64) (d, verdict string) {
+	if v0 == 0 {
+		if v1 == 0 {
+			return "+0.00%", ""
+		}
+		return "n/a", ""
+	}
+	r := v1/v0 - 1
+	threshold := m.Threshold
+	if threshold == 0 {
+		threshold = regress.DefaultMinChange
+	}
+	if math.Abs(r) >= threshold {
+		verdict = "better"
+		if m.Worse(v0, v1) {
+			verdict = "worse"
+		}
+	}
+	return fmt.Sprintf("%+.2f%%", r*100), verdict
+}
+
+var (
+	benchRe  = regexp.MustCompile("^GOPERF-BENCH:(.+)$")
+	errorRe  = regexp.MustCompile("^GOPERF-ERROR:(.*)$")
+	metricRe = regexp.MustCompile("^GOPERF-METRIC:([a-zA-Z0-9_.-]+)=([0-9]+)$")
+)
+
+// benchRun runs the selected benchmarks and returns their names in the order
+// of output and their metrics. When several benchmarks are selected,
+// the output of each one starts with a GOPERF-BENCH line.
+// Failed benchmarks have no metrics.
+func benchRun(bin, procs, aff string) ([]string, map[string]Metrics) {
 	os.Setenv("GOMAXPROCS", procs)
 	cmd := exec.Command(bin,
-		"-bench", bench,
+		"-bench", *benchList,
 		"-benchnum", strconv.Itoa(*benchNum),
 		"-benchmem", strconv.Itoa(*benchMem),
 		"-benchtime", benchTime.String(),
 		"-affinity", aff)
 	out, err := cmd.CombinedOutput()
-	if err != nil {
+	names, results := parseOutput(bin, out, *benchList)
+	if err != nil && len(results) == 0 {
 		fmt.Fprintf(os.Stderr, "%v failed: %v\n%s\n", cmd.Args, err, out)
 		os.Exit(1)
 	}
-	metrics := make(Metrics)
+	return names, results
+}
+
+// parseOutput splits the output of the bench binary into benchmarks.
+// Output of a single benchmark does not have GOPERF-BENCH line,
+// its metrics are attributed to list.
+func parseOutput(bin string, out []byte, list string) ([]string, map[string]Metrics) {
+	var names []string
+	results := make(map[string]Metrics)
+	cur := list
 	s := bufio.NewScanner(bytes.NewReader(out))
-	metricRe := regexp.MustCompile("^GOPERF-METRIC:([a-z,0-9,-]+)=([0-9]+)$")
 	for s.Scan() {
-		ss := metricRe.FindStringSubmatch(s.Text())
+		ln := s.Text()
+		if ss := benchRe.FindStringSubmatch(ln); ss != nil {
+			cur = ss[1]
+			names = append(names, cur)
+			continue
+		}
+		if ss := errorRe.FindStringSubmatch(ln); ss != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v failed: %v\n", bin, cur, ss[1])
+			continue
+		}
+		ss := metricRe.FindStringSubmatch(ln)
 		if ss == nil {
 			continue
 		}
-		var v uint64
 		v, err := strconv.ParseUint(ss[2], 10, 64)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse metric '%v=%v': %v", ss[1], ss[2], err)
+			fmt.Fprintf(os.Stderr, "failed to parse metric '%v=%v': %v\n", ss[1], ss[2], err)
 			continue
 		}
-		metrics[ss[1]] = v
+		if results[cur] == nil {
+			results[cur] = make(Metrics)
+			if len(names) == 0 {
+				names = append(names, cur)
+			}
+		}
+		results[cur][ss[1]] = v
 	}
-	return metrics
+	return names, results
 }
@@ -0,0 +1,112 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"code.google.com/p/goperfd/config"
+)
+
+func TestParseOutput(t *testing.T) {
+	for _, c := range []struct {
+		desc    string
+		list    string
+		out     string
+		names   []string
+		results map[string]Metrics
+	}{
+		{
+			"single",
+			"json",
+			"some log line\nGOPERF-METRIC:time=100\nGOPERF-METRIC:rss=2000\nGOPERF-FILE:cpuprof=/tmp/x\n",
+			[]string{"json"},
+			map[string]Metrics{"json": {"time": 100, "rss": 2000}},
+		},
+		{
+			"several",
+			"json,http/body=64k",
+			"GOPERF-BENCH:json\nGOPERF-METRIC:time=100\nGOPERF-BENCH:http/body=64k\nGOPERF-METRIC:time=200\nGOPERF-METRIC:latency-50=30\n",
+			[]string{"json", "http/body=64k"},
+			map[string]Metrics{"json": {"time": 100}, "http/body=64k": {"time": 200, "latency-50": 30}},
+		},
+		{
+			"failed",
+			"*",
+			"GOPERF-BENCH:garbage\nGOPERF-ERROR:out of memory\nGOPERF-BENCH:json\nGOPERF-METRIC:time=100\n",
+			[]string{"garbage", "json"},
+			map[string]Metrics{"json": {"time": 100}},
+		},
+		{
+			"bad metric",
+			"json",
+			"GOPERF-METRIC:time=100\nGOPERF-METRIC:rss=99999999999999999999\nGOPERF-METRIC:allocs=-1\n",
+			[]string{"json"},
+			map[string]Metrics{"json": {"time": 100}},
+		},
+		{
+			"no output",
+			"json",
+			"panic: boom\n",
+			nil,
+			map[string]Metrics{},
+		},
+	} {
+		names, results := parseOutput("bench", []byte(c.out), c.list)
+		if !reflect.DeepEqual(names, c.names) {
+			t.Errorf("%v: got names %q, want %q", c.desc, names, c.names)
+		}
+		if !reflect.DeepEqual(results, c.results) {
+			t.Errorf("%v: got %v, want %v", c.desc, results, c.results)
+		}
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { cfg = new(config.ProjectConfig) }()
+	for name, unit := range map[string]string{
+		"time":          config.UnitNs,
+		"allocs":        config.UnitCount,
+		"rss":           config.UnitBytes,
+		"latency-99.99": config.UnitNs,
+	} {
+		if m := cfg.Metric(name); m.Unit != unit {
+			t.Errorf("metric %v has unit '%v', want '%v'", name, m.Unit, unit)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	lower := &config.Metric{Name: "time"}
+	higher := &config.Metric{Name: "ops", Better: config.BetterHigher, Threshold: 0.1}
+	for _, c := range []struct {
+		m       *config.Metric
+		v0, v1  float64
+		d       string
+		verdict string
+	}{
+		{lower, 100, 100, "+0.00%", ""},
+		{lower, 100, 101, "+1.00%", ""},
+		{lower, 100, 110, "+10.00%", "worse"},
+		{lower, 100, 90, "-10.00%", "better"},
+		{lower, 100, 0, "-100.00%", "better"},
+		{higher, 100, 105, "+5.00%", ""},
+		{higher, 100, 120, "+20.00%", "better"},
+		{higher, 100, 80, "-20.00%", "worse"},
+		// Zero values are common for allocs and GC metrics.
+		{lower, 0, 0, "+0.00%", ""},
+		{lower, 0, 5, "n/a", ""},
+		{higher, 0, 5, "n/a", ""},
+	} {
+		d, verdict := compare(c.m, c.v0, c.v1)
+		if d != c.d || verdict != c.verdict {
+			t.Errorf("%v %v -> %v: got %v %q, want %v %q", c.m.Name, c.v0, c.v1, d, verdict, c.d, c.verdict)
+		}
+	}
+}
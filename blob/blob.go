@@ -0,0 +1,203 @@
+// Package blob implements content-addressed storage for benchmark artifacts.
+//
+// A blob is identified by the SHA-256 hash of its contents, so identical
+// artifacts (e.g. a binary section list that does not change between revisions)
+// are stored once. Blobs are optionally gzip-compressed on disk.
+package blob
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Store struct {
+	dir      string
+	compress bool
+
+	// mu serializes Put and DeleteOlder, so that a blob that is stored again
+	// is not deleted after its modification time has been checked.
+	mu sync.Mutex
+}
+
+const gzSuffix = ".gz"
+
+// Open opens or creates a store in dir.
+// If compress is set, new blobs are compressed.
+func Open(dir string, compress bool) (*Store, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, compress: compress}, nil
+}
+
+// Hash returns the identifier of data.
+func Hash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// Put stores data and returns its hash. Storing already existing data is cheap.
+func (s *Store) Put(data []byte) (string, error) {
+	hash := Hash(data)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Has(hash) {
+		// Refresh modification time, so that the blob is not collected
+		// before the caller references it (see List).
+		now := time.Now()
+		if err := os.Chtimes(s.path(hash), now, now); err != nil {
+			os.Chtimes(s.path(hash)+gzSuffix, now, now)
+		}
+		return hash, nil
+	}
+	path := s.path(hash)
+	if s.compress {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			return "", err
+		}
+		data = buf.Bytes()
+		path += gzSuffix
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return "", err
+	}
+	// Write to a temp file and rename, so that a partially written blob is never visible.
+	f, err := ioutil.TempFile(filepath.Dir(path), "tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return hash, nil
+}
+
+// Get returns contents of the blob.
+func (s *Store) Get(hash string) ([]byte, error) {
+	if !validHash(hash) {
+		return nil, fmt.Errorf("bad blob hash '%v'", hash)
+	}
+	data, err := ioutil.ReadFile(s.path(hash))
+	if err == nil || !os.IsNotExist(err) {
+		return data, err
+	}
+	data, err = ioutil.ReadFile(s.path(hash) + gzSuffix)
+	if err != nil {
+		return nil, err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// Has returns whether the blob exists.
+func (s *Store) Has(hash string) bool {
+	if !validHash(hash) {
+		return false
+	}
+	if _, err := os.Stat(s.path(hash)); err == nil {
+		return true
+	}
+	_, err := os.Stat(s.path(hash) + gzSuffix)
+	return err == nil
+}
+
+// Info describes a stored blob.
+type Info struct {
+	Hash string
+	Size int64     // size on disk
+	Time time.Time // last time the blob was stored
+}
+
+// List returns all blobs in the store. Blobs that were stored recently
+// may be not yet referenced by their users, a garbage collector
+// should not delete them.
+func (s *Store) List() ([]*Info, error) {
+	var res []*Info
+	err := filepath.Walk(s.dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		hash := strings.TrimSuffix(strings.Replace(filepath.ToSlash(rel), "/", "", 1), gzSuffix)
+		if !validHash(hash) {
+			return nil // e.g. a temp file
+		}
+		res = append(res, &Info{Hash: hash, Size: fi.Size(), Time: fi.ModTime()})
+		return nil
+	})
+	return res, err
+}
+
+// Delete deletes the blob.
+func (s *Store) Delete(hash string) error {
+	if !validHash(hash) {
+		return fmt.Errorf("bad blob hash '%v'", hash)
+	}
+	err := os.Remove(s.path(hash))
+	if os.IsNotExist(err) {
+		err = os.Remove(s.path(hash) + gzSuffix)
+	}
+	return err
+}
+
+// DeleteOlder deletes the blob if it was last stored before t.
+// It returns whether the blob was deleted. Unlike a check of List
+// followed by Delete, it does not race with Put of the same data.
+func (s *Store) DeleteOlder(hash string, t time.Time) (bool, error) {
+	if !validHash(hash) {
+		return false, fmt.Errorf("bad blob hash '%v'", hash)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.path(hash)
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		path += gzSuffix
+		fi, err = os.Stat(path)
+	}
+	if err != nil {
+		return false, err
+	}
+	if !fi.ModTime().Before(t) {
+		return false, nil
+	}
+	return true, os.Remove(path)
+}
+
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash[2:])
+}
+
+func validHash(hash string) bool {
+	if len(hash) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
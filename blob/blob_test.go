@@ -0,0 +1,197 @@
+package blob
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, compress bool) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "goperfd-blob-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(filepath.Join(dir, "blobs"), compress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+// age sets modification time of the blob to d ago.
+func age(s *Store, hash string, d time.Duration) {
+	t := time.Now().Add(-d)
+	os.Chtimes(s.path(hash), t, t)
+	os.Chtimes(s.path(hash)+gzSuffix, t, t)
+}
+
+func TestPutGet(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		s, cleanup := newTestStore(t, compress)
+		defer cleanup()
+		blobs := [][]byte{
+			[]byte("cpu profile"),
+			{},
+			bytes.Repeat([]byte("perf report line\n"), 1e5),
+		}
+		for _, data := range blobs {
+			hash, err := s.Put(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != Hash(data) || !s.Has(hash) {
+				t.Errorf("compress=%v: bad hash %v of %v bytes", compress, hash, len(data))
+			}
+			got, err := s.Get(hash)
+			if err != nil {
+				t.Fatalf("compress=%v: %v", compress, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("compress=%v: got %v bytes, want %v", compress, len(got), len(data))
+			}
+		}
+		list, err := s.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != len(blobs) {
+			t.Fatalf("compress=%v: listed %v blobs, want %v", compress, len(list), len(blobs))
+		}
+		for _, b := range list {
+			big := b.Hash == Hash(blobs[2])
+			if big && compress && b.Size >= int64(len(blobs[2]))/10 {
+				t.Errorf("compressed blob takes %v bytes", b.Size)
+			}
+			if big && !compress && b.Size != int64(len(blobs[2])) {
+				t.Errorf("blob takes %v bytes, want %v", b.Size, len(blobs[2]))
+			}
+		}
+	}
+}
+
+func TestDedup(t *testing.T) {
+	s, cleanup := newTestStore(t, true)
+	defer cleanup()
+	data := []byte("section sizes")
+	hash, err := s.Put(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	age(s, hash, 24*time.Hour)
+	// A store that does not compress finds the compressed blob.
+	s1 := &Store{dir: s.dir}
+	hash1, err := s1.Put(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash1 != hash {
+		t.Fatalf("got hash %v, want %v", hash1, hash)
+	}
+	list, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("same data is stored %v times", len(list))
+	}
+	// Storing the data again makes the blob new, so that it is not collected
+	// before it is referenced.
+	if time.Since(list[0].Time) > time.Hour {
+		t.Errorf("modification time %v is not refreshed", list[0].Time)
+	}
+	if got, err := s1.Get(hash); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestBadHash(t *testing.T) {
+	s, cleanup := newTestStore(t, false)
+	defer cleanup()
+	for _, hash := range []string{"", "abc", strings.Repeat("g", 64), "../" + strings.Repeat("0", 61)} {
+		if s.Has(hash) {
+			t.Errorf("%q: exists", hash)
+		}
+		if _, err := s.Get(hash); err == nil {
+			t.Errorf("%q: Get succeeded", hash)
+		}
+		if err := s.Delete(hash); err == nil {
+			t.Errorf("%q: Delete succeeded", hash)
+		}
+		if _, err := s.DeleteOlder(hash, time.Now()); err == nil {
+			t.Errorf("%q: DeleteOlder succeeded", hash)
+		}
+	}
+	missing := Hash([]byte("missing"))
+	if _, err := s.Get(missing); !os.IsNotExist(err) {
+		t.Errorf("missing blob: got %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	s, cleanup := newTestStore(t, false)
+	defer cleanup()
+	hash, err := s.Put([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A temp file of an interrupted Put.
+	if err := ioutil.WriteFile(filepath.Join(filepath.Dir(s.path(hash)), "tmp123"), []byte("da"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	list, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Hash != hash || list[0].Size != 4 {
+		t.Errorf("got %+v", list)
+	}
+}
+
+func TestDeleteOlder(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		s, cleanup := newTestStore(t, compress)
+		defer cleanup()
+		old, err := s.Put([]byte("old"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		age(s, old, 2*time.Hour)
+		fresh, err := s.Put([]byte("fresh"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reused, err := s.Put([]byte("reused"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		age(s, reused, 2*time.Hour)
+		cutoff := time.Now().Add(-time.Hour)
+		// A collector has listed the blobs, then reused is stored again.
+		list, err := s.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Put([]byte("reused")); err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range list {
+			deleted, err := s.DeleteOlder(b.Hash, cutoff)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := b.Hash == old; deleted != want {
+				t.Errorf("compress=%v: blob %v: deleted %v, want %v", compress, b.Hash, deleted, want)
+			}
+		}
+		if s.Has(old) || !s.Has(fresh) || !s.Has(reused) {
+			t.Errorf("compress=%v: got old %v, fresh %v, reused %v", compress, s.Has(old), s.Has(fresh), s.Has(reused))
+		}
+		if _, err := s.DeleteOlder(old, cutoff); !os.IsNotExist(err) {
+			t.Errorf("compress=%v: deleted blob: got %v", compress, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package builder
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.google.com/p/goperfd/config"
+)
+
+// Request headers that carry the signature.
+const (
+	MachineHeader   = "X-Goperf-Machine"
+	TimeHeader      = "X-Goperf-Time"
+	NonceHeader     = "X-Goperf-Nonce"
+	SignatureHeader = "X-Goperf-Signature"
+)
+
+const (
+	// maxSkew is the max difference between the request time and the server clock.
+	// Nonces are remembered for twice as long, so a request can't be replayed.
+	maxSkew     = 5 * time.Minute
+	maxNonceLen = 64
+)
+
+// SigningKey derives the key that signs requests of the machine from its Machine.Key.
+func SigningKey(machine, key string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("goperfd builder " + machine))
+	return mac.Sum(nil)
+}
+
+// Signature returns the hex-encoded signature of a request.
+func Signature(signingKey []byte, method, path, query, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, signingKey)
+	fmt.Fprintf(mac, "%v\n%v\n%v\n%v\n%v\n%x", method, path, query, timestamp, nonce, bodyHash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign adds signature headers to the request. body must be the request body.
+func Sign(r *http.Request, machine, key string, body []byte) error {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	t := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := hex.EncodeToString(buf[:])
+	r.Header.Set(MachineHeader, machine)
+	r.Header.Set(TimeHeader, t)
+	r.Header.Set(NonceHeader, nonce)
+	r.Header.Set(SignatureHeader, Signature(SigningKey(machine, key), r.Method, r.URL.Path, r.URL.RawQuery, t, nonce, body))
+	return nil
+}
+
+// authenticate verifies the request signature and returns the machine
+// that issued the request along with the request body.
+func (h *handlers) authenticate(cfg *config.ProjectConfig, w http.ResponseWriter, r *http.Request, maxSize int64) (*config.Machine, []byte, error) {
+	name := r.Header.Get(MachineHeader)
+	t := r.Header.Get(TimeHeader)
+	nonce := r.Header.Get(NonceHeader)
+	sig := r.Header.Get(SignatureHeader)
+	if name == "" || t == "" || nonce == "" || sig == "" {
+		return nil, nil, fmt.Errorf("request is not signed")
+	}
+	var m *config.Machine
+	for i := range cfg.Machines {
+		if cfg.Machines[i].Name == name {
+			m = &cfg.Machines[i]
+		}
+	}
+	if m == nil {
+		return nil, nil, fmt.Errorf("unknown machine '%v'", name)
+	}
+	sec, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad time '%v'", t)
+	}
+	now := time.Now()
+	if skew := now.Sub(time.Unix(sec, 0)); skew > maxSkew || skew < -maxSkew {
+		return nil, nil, fmt.Errorf("request time is off by %v", skew)
+	}
+	if len(nonce) > maxNonceLen {
+		return nil, nil, fmt.Errorf("nonce is too long")
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read request: %v", err)
+	}
+	want := Signature(SigningKey(m.Name, m.Key), r.Method, r.URL.Path, r.URL.RawQuery, t, nonce, body)
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return nil, nil, fmt.Errorf("bad signature for machine '%v'", name)
+	}
+	if !h.nonces.add(name+"/"+nonce, now) {
+		return nil, nil, fmt.Errorf("replayed request from machine '%v'", name)
+	}
+	return m, body, nil
+}
+
+// nonceCache remembers nonces of recent requests.
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// add returns false if the nonce was already used.
+func (c *nonceCache) add(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen == nil {
+		c.seen = make(map[string]time.Time)
+	}
+	for n, t := range c.seen {
+		if now.Sub(t) > 2*maxSkew {
+			delete(c.seen, n)
+		}
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	c.seen[nonce] = now
+	return true
+}
@@ -0,0 +1,158 @@
+package builder
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/goperfd/config"
+)
+
+var authConfig = &config.ProjectConfig{
+	Name:     "authtest",
+	Machines: []config.Machine{{Name: "m1", Key: "key1"}, {Name: "m2", Key: "key2"}},
+}
+
+// signedRequest creates a request signed with the given time and nonce.
+func signedRequest(method, url, machine, key string, t time.Time, nonce string, body []byte) *http.Request {
+	r := httptest.NewRequest(method, url, bytes.NewReader(body))
+	ts := strconv.FormatInt(t.Unix(), 10)
+	r.Header.Set(MachineHeader, machine)
+	r.Header.Set(TimeHeader, ts)
+	r.Header.Set(NonceHeader, nonce)
+	r.Header.Set(SignatureHeader, Signature(SigningKey(machine, key), method, r.URL.Path, r.URL.RawQuery, ts, nonce, body))
+	return r
+}
+
+func authenticate(h *handlers, r *http.Request) (*config.Machine, []byte, error) {
+	return h.authenticate(authConfig, httptest.NewRecorder(), r, 1<<20)
+}
+
+func TestSign(t *testing.T) {
+	h := new(handlers)
+	body := []byte(`{"Job":"1"}`)
+	r := httptest.NewRequest("POST", "/authtest/builder/result", bytes.NewReader(body))
+	if err := Sign(r, "m2", "key2", body); err != nil {
+		t.Fatal(err)
+	}
+	m, data, err := authenticate(h, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "m2" || string(data) != string(body) {
+		t.Errorf("got machine %v and body %q", m.Name, data)
+	}
+}
+
+func TestBadSignature(t *testing.T) {
+	h := new(handlers)
+	now := time.Now()
+	body := []byte(`{"Job":"1"}`)
+	good := signedRequest("POST", "/authtest/builder/result?a=1", "m1", "key1", now, "n1", body)
+	for _, c := range []struct {
+		desc   string
+		modify func(r *http.Request)
+	}{
+		{"wrong key", func(r *http.Request) {
+			*r = *signedRequest("POST", "/authtest/builder/result?a=1", "m1", "key2", now, "n2", body)
+		}},
+		{"other machine", func(r *http.Request) { r.Header.Set(MachineHeader, "m2") }},
+		{"unknown machine", func(r *http.Request) { r.Header.Set(MachineHeader, "m3") }},
+		{"method", func(r *http.Request) { r.Method = "PUT" }},
+		{"path", func(r *http.Request) { r.URL.Path = "/authtest/builder/heartbeat" }},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "a=2" }},
+		{"time", func(r *http.Request) { r.Header.Set(TimeHeader, strconv.FormatInt(now.Unix()+1, 10)) }},
+		{"nonce", func(r *http.Request) { r.Header.Set(NonceHeader, "n3") }},
+		{"body", func(r *http.Request) {
+			r.Body = httptest.NewRequest("POST", "/", strings.NewReader(`{"Job":"2"}`)).Body
+		}},
+		{"signature", func(r *http.Request) { r.Header.Set(SignatureHeader, strings.Repeat("0", 64)) }},
+		{"unsigned", func(r *http.Request) { r.Header.Del(SignatureHeader) }},
+		{"no nonce", func(r *http.Request) { r.Header.Del(NonceHeader) }},
+		{"bad time", func(r *http.Request) { r.Header.Set(TimeHeader, "yesterday") }},
+		{"long nonce", func(r *http.Request) {
+			*r = *signedRequest("POST", "/authtest/builder/result?a=1", "m1", "key1", now, strings.Repeat("n", maxNonceLen+1), body)
+		}},
+	} {
+		r := signedRequest("POST", "/authtest/builder/result?a=1", "m1", "key1", now, good.Header.Get(NonceHeader), body)
+		c.modify(r)
+		if _, _, err := authenticate(h, r); err == nil {
+			t.Errorf("%v: request accepted", c.desc)
+		}
+	}
+	// None of the above has used the nonce.
+	if _, _, err := authenticate(h, good); err != nil {
+		t.Errorf("good request rejected: %v", err)
+	}
+}
+
+func TestSkew(t *testing.T) {
+	h := new(handlers)
+	now := time.Now()
+	for i, c := range []struct {
+		skew time.Duration
+		ok   bool
+	}{
+		{0, true},
+		{4 * time.Minute, true},
+		{-4 * time.Minute, true},
+		{6 * time.Minute, false},
+		{-6 * time.Minute, false},
+		{24 * time.Hour, false},
+	} {
+		r := signedRequest("GET", "/authtest/builder/work", "m1", "key1", now.Add(c.skew), "skew"+strconv.Itoa(i), nil)
+		if _, _, err := authenticate(h, r); (err == nil) != c.ok {
+			t.Errorf("skew %v: got error %v, want ok=%v", c.skew, err, c.ok)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	h := new(handlers)
+	now := time.Now()
+	if _, _, err := authenticate(h, signedRequest("GET", "/authtest/builder/work", "m1", "key1", now, "n", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := authenticate(h, signedRequest("GET", "/authtest/builder/work", "m1", "key1", now, "n", nil)); err == nil {
+		t.Errorf("replayed request accepted")
+	}
+	// The request is not replayed to another endpoint either.
+	if _, _, err := authenticate(h, signedRequest("POST", "/authtest/builder/heartbeat", "m1", "key1", now, "n", nil)); err == nil {
+		t.Errorf("replayed nonce accepted on another endpoint")
+	}
+	// Nonces are per machine.
+	if _, _, err := authenticate(h, signedRequest("GET", "/authtest/builder/work", "m2", "key2", now, "n", nil)); err != nil {
+		t.Errorf("nonce of another machine rejected: %v", err)
+	}
+}
+
+func TestNonceExpiry(t *testing.T) {
+	var c nonceCache
+	t0 := time.Now()
+	if !c.add("m1/a", t0) {
+		t.Fatalf("new nonce rejected")
+	}
+	if c.add("m1/a", t0.Add(2*maxSkew)) {
+		t.Errorf("nonce accepted again within %v", 2*maxSkew)
+	}
+	if !c.add("m1/b", t0.Add(time.Minute)) {
+		t.Errorf("new nonce rejected")
+	}
+	// Once the request time can't pass the skew check, the nonce is forgotten.
+	if !c.add("m1/a", t0.Add(2*maxSkew+time.Second)) {
+		t.Errorf("expired nonce rejected")
+	}
+	if len(c.seen) != 2 {
+		t.Errorf("cache has %v nonces, want 2", len(c.seen))
+	}
+	if c.add("m1/b", t0.Add(2*maxSkew+time.Second)) {
+		t.Errorf("nonce accepted again within %v", 2*maxSkew)
+	}
+	if !c.add("m1/c", t0.Add(time.Hour)) || len(c.seen) != 1 {
+		t.Errorf("cache has %v nonces after an hour, want 1", len(c.seen))
+	}
+}
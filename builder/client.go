@@ -0,0 +1,99 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client talks to goperfd on behalf of a builder machine.
+type Client struct {
+	Server  string // project URL, e.g. http://localhost:33333/Go
+	Machine string
+	Key     string
+	HTTP    *http.Client
+}
+
+func NewClient(server, machine, key string) *Client {
+	return &Client{
+		Server:  server,
+		Machine: machine,
+		Key:     key,
+		HTTP:    &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// Work asks for the next job. It returns nil if there is nothing to do.
+func (c *Client) Work() (*Job, error) {
+	resp, err := c.do("GET", "/builder/work", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	job := new(Job)
+	if err := json.NewDecoder(resp.Body).Decode(job); err != nil {
+		return nil, fmt.Errorf("failed to decode job: %v", err)
+	}
+	return job, nil
+}
+
+// Report uploads results of a job.
+func (c *Client) Report(rep *Report) error {
+	data, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do("POST", "/builder/result", data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+// Heartbeat tells goperfd that the builder is alive.
+func (c *Client) Heartbeat(hb *Heartbeat) error {
+	data, err := json.Marshal(hb)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do("POST", "/builder/heartbeat", data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+// do sends a signed request.
+func (c *Client) do(method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.Server, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := Sign(req, c.Machine, c.Key, body); err != nil {
+		return nil, err
+	}
+	return c.HTTP.Do(req)
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("%v: %s", resp.Status, bytes.TrimSpace(body))
+}
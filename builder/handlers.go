@@ -1,5 +1,229 @@
+// Package builder implements the protocol between goperfd and builder machines.
+//
+// Every project is served under its own path prefix (see config.ProjectConfig.URLPath).
+// A builder polls /<project>/builder/work for the next job. The response is
+// a JSON-encoded Job, or 204 No Content if there is nothing to do at the moment.
+// While the job runs, the builder periodically POSTs a JSON-encoded Heartbeat
+// to /<project>/builder/heartbeat, this extends the job lease. Any request
+// tells goperfd that the builder is alive.
+// When the job is finished, the builder POSTs a JSON-encoded Report
+// to /<project>/builder/result. A report for a job that is not leased
+// to the machine (e.g. the lease has expired) is rejected with 409 Conflict.
+//
+// Every request is signed by the machine, the key is never sent over the wire.
+// The signing key is HMAC-SHA256(Machine.Key, "goperfd builder " + Machine.Name).
+// A request carries the following headers:
+//
+//	X-Goperf-Machine:   machine name
+//	X-Goperf-Time:      request time, seconds since Unix epoch
+//	X-Goperf-Nonce:     random string unique for every request, up to 64 bytes
+//	X-Goperf-Signature: hex(HMAC-SHA256(signing key, method + "\n" + path + "\n" +
+//	                    query + "\n" + time + "\n" + nonce + "\n" + hex(SHA256(body))))
+//
+// path is the URL path including the project prefix, e.g. /Go/builder/result,
+// query is the raw URL query (empty for all current endpoints). Requests whose
+// time differs from the server clock by more than 5 minutes, and requests
+// with a nonce that was already used are rejected. Go uploaders can use Client or Sign.
+//
+// The project config can be reloaded at any time. A handler takes a snapshot
+// of the config and passes it to the Backend, so a request is served with
+// a consistent config.
 package builder
 
-func RegisterHandlers() error {
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+
+	"code.google.com/p/goperfd/config"
+)
+
+// Job describes a single benchmark to run on a single revision.
+type Job struct {
+	Id        string
+	Rev       string
+	Benchmark string
+	Procs     []int    // GOMAXPROCS values to run the benchmark with
+	Flags     []string // additional flags for the bench binary
+}
+
+// Report is the outcome of a Job.
+type Report struct {
+	Job       string // Job.Id
+	Rev       string
+	Benchmark string
+	Runs      []Run
+	Error     string // non-empty if the job has failed
+}
+
+// Run is the output of a single bench binary invocation (GOPERF-METRIC and GOPERF-FILE lines).
+type Run struct {
+	Procs   int
+	Metrics map[string]uint64
+	Files   map[string][]byte
+}
+
+// Heartbeat tells that the builder is alive and what it is doing.
+type Heartbeat struct {
+	Job      string  // Job.Id of the current job, empty if idle
+	Status   string  // e.g. "building toolchain"
+	Progress float64 // fraction of the job that is done, from 0 to 1
+}
+
+// Backend hands out jobs and consumes reports.
+type Backend interface {
+	// NextJob returns the next job for the machine, or nil if there is nothing to do.
+	NextJob(cfg *config.ProjectConfig, m *config.Machine) (*Job, error)
+	// Complete stores the report. It returns ErrUnknownJob if the report
+	// does not match a job that is leased to the machine.
+	Complete(cfg *config.ProjectConfig, m *config.Machine, rep *Report) error
+	Heartbeat(cfg *config.ProjectConfig, m *config.Machine, hb *Heartbeat) error
+}
+
+const (
+	maxReportSize  = 64 << 20
+	maxRequestSize = 64 << 10 // for other requests
+)
+
+var (
+	metricNameRe = regexp.MustCompile("^[a-zA-Z0-9_.-]+$")
+	fileNameRe   = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+)
+
+// ErrUnknownJob is returned by Backend.Complete for a report that does not
+// match a job leased to the machine.
+var ErrUnknownJob = errors.New("unknown or expired job")
+
+// handlers serves builders of a single project.
+type handlers struct {
+	project *config.ProjectFile
+	backend Backend
+	nonces  nonceCache
+}
+
+// RegisterHandlers registers builder endpoints of the project.
+func RegisterHandlers(project *config.ProjectFile, b Backend) error {
+	h := &handlers{project: project, backend: b}
+	prefix := project.Get().URLPath()
+	http.HandleFunc(prefix+"/builder/work", h.handleWork)
+	http.HandleFunc(prefix+"/builder/result", h.handleResult)
+	http.HandleFunc(prefix+"/builder/heartbeat", h.handleHeartbeat)
+	return nil
+}
+
+func (h *handlers) handleWork(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := h.project.Get()
+	m, _, err := h.authenticate(cfg, w, r, maxRequestSize)
+	if err != nil {
+		log.Printf("builder: work request from %v rejected: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	job, err := h.backend.NextJob(cfg, m)
+	if err != nil {
+		log.Printf("builder: failed to choose job for '%v': %v", m.Name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		log.Printf("builder: failed to send job to '%v': %v", m.Name, err)
+	}
+}
+
+func (h *handlers) handleResult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := h.project.Get()
+	m, body, err := h.authenticate(cfg, w, r, maxReportSize)
+	if err != nil {
+		log.Printf("builder: result from %v rejected: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	rep := new(Report)
+	if err := json.Unmarshal(body, rep); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode report: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := checkReport(cfg, rep); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.backend.Complete(cfg, m, rep); err == ErrUnknownJob {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("builder: failed to process report from '%v': %v", m.Name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *handlers) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := h.project.Get()
+	m, body, err := h.authenticate(cfg, w, r, maxRequestSize)
+	if err != nil {
+		log.Printf("builder: heartbeat from %v rejected: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	hb := new(Heartbeat)
+	if err := json.Unmarshal(body, hb); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode heartbeat: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := h.backend.Heartbeat(cfg, m, hb); err != nil {
+		log.Printf("builder: failed to process heartbeat from '%v': %v", m.Name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func checkReport(cfg *config.ProjectConfig, rep *Report) error {
+	if rep.Job == "" || rep.Rev == "" {
+		return fmt.Errorf("report does not specify job or revision")
+	}
+	known := false
+	for _, b := range cfg.Benchmarks {
+		if b.Name == rep.Benchmark {
+			known = true
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown benchmark '%v'", rep.Benchmark)
+	}
+	for _, run := range rep.Runs {
+		if run.Procs <= 0 {
+			return fmt.Errorf("bad GOMAXPROCS value %v", run.Procs)
+		}
+		for name := range run.Metrics {
+			if !metricNameRe.MatchString(name) {
+				return fmt.Errorf("bad metric name '%v'", name)
+			}
+		}
+		for name := range run.Files {
+			if !fileNameRe.MatchString(name) {
+				return fmt.Errorf("bad file name '%v'", name)
+			}
+		}
+	}
 	return nil
 }
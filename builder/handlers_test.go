@@ -0,0 +1,231 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"code.google.com/p/goperfd/config"
+)
+
+// fakeBackend hands out a single job and records what it receives.
+type fakeBackend struct {
+	mu         sync.Mutex
+	job        *Job
+	reports    []*Report
+	heartbeats []*Heartbeat
+}
+
+func (b *fakeBackend) NextJob(cfg *config.ProjectConfig, m *config.Machine) (*Job, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job := b.job
+	b.job = nil
+	return job, nil
+}
+
+func (b *fakeBackend) setJob(job *Job) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.job = job
+}
+
+func (b *fakeBackend) Complete(cfg *config.ProjectConfig, m *config.Machine, rep *Report) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if rep.Job == "stale" {
+		return ErrUnknownJob
+	}
+	b.reports = append(b.reports, rep)
+	return nil
+}
+
+func (b *fakeBackend) Heartbeat(cfg *config.ProjectConfig, m *config.Machine, hb *Heartbeat) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.heartbeats = append(b.heartbeats, hb)
+	return nil
+}
+
+var (
+	testServerOnce sync.Once
+	testServer     *httptest.Server
+	testBackend    = new(fakeBackend)
+)
+
+// startServer serves handlers of project "buildertest" with testBackend.
+// Handlers are registered in http.DefaultServeMux, so it is done once.
+func startServer(t *testing.T) string {
+	testServerOnce.Do(func() {
+		project := new(config.ProjectFile)
+		project.Set(&config.ProjectConfig{
+			Name:       "buildertest",
+			Benchmarks: []config.Benchmark{{Name: "json"}},
+			Machines:   []config.Machine{{Name: "m1", Key: "key1"}, {Name: "m2", Key: "key2"}},
+		})
+		if err := RegisterHandlers(project, testBackend); err != nil {
+			t.Fatal(err)
+		}
+		testServer = httptest.NewServer(http.DefaultServeMux)
+	})
+	return testServer.URL + "/buildertest"
+}
+
+// send sends a request signed with the key, or an unsigned request if key is empty.
+func send(t *testing.T, method, url, machine, key string, body []byte) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "" {
+		if err := Sign(req, machine, key, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+func TestWork(t *testing.T) {
+	server := startServer(t)
+	testBackend.setJob(&Job{Id: "1", Rev: "abc", Benchmark: "json", Procs: []int{1, 4}})
+	c := NewClient(server, "m1", "key1")
+	job, err := c.Work()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.Id != "1" || job.Rev != "abc" || len(job.Procs) != 2 {
+		t.Fatalf("got job %+v", job)
+	}
+	if job, err := c.Work(); err != nil || job != nil {
+		t.Fatalf("got job %+v, %v when there is nothing to do", job, err)
+	}
+	if resp := send(t, "POST", server+"/builder/work", "m1", "key1", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST to work: got %v", resp.Status)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	server := startServer(t)
+	for _, path := range []string{"/builder/work", "/builder/heartbeat", "/builder/result"} {
+		method := "POST"
+		if path == "/builder/work" {
+			method = "GET"
+		}
+		url := server + path
+		for _, c := range []struct {
+			desc, machine, key string
+		}{
+			{"unsigned", "m1", ""},
+			{"unknown machine", "m3", "key1"},
+			{"bad key", "m1", "key2"},
+			{"key of another machine", "m2", "key1"},
+		} {
+			if resp := send(t, method, url, c.machine, c.key, []byte("{}")); resp.StatusCode != http.StatusForbidden {
+				t.Errorf("%v to %v: got %v, want 403", c.desc, path, resp.Status)
+			}
+		}
+
+		// The body is modified after signing.
+		req, _ := http.NewRequest(method, url, strings.NewReader(`{"Job":"2"}`))
+		Sign(req, "m1", "key1", []byte(`{"Job":"1"}`))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("bad signature to %v: got %v, want 403", path, resp.Status)
+		}
+
+		// The same request is sent twice.
+		body := []byte(`{}`)
+		req, _ = http.NewRequest(method, url, bytes.NewReader(body))
+		Sign(req, "m1", "key1", body)
+		header := req.Header
+		for i := 0; i < 2; i++ {
+			req, _ = http.NewRequest(method, url, bytes.NewReader(body))
+			req.Header = header
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if i == 1 && resp.StatusCode != http.StatusForbidden {
+				t.Errorf("replayed request to %v: got %v, want 403", path, resp.Status)
+			}
+		}
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	server := startServer(t)
+	c := NewClient(server, "m2", "key2")
+	if err := c.Heartbeat(&Heartbeat{Job: "1", Status: "building", Progress: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	testBackend.mu.Lock()
+	hb := testBackend.heartbeats[len(testBackend.heartbeats)-1]
+	testBackend.mu.Unlock()
+	if hb.Job != "1" || hb.Status != "building" || hb.Progress != 0.5 {
+		t.Errorf("backend got heartbeat %+v", hb)
+	}
+	if resp := send(t, "POST", server+"/builder/heartbeat", "m2", "key2", []byte("{")); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("malformed heartbeat: got %v, want 400", resp.Status)
+	}
+}
+
+func TestResult(t *testing.T) {
+	server := startServer(t)
+	c := NewClient(server, "m1", "key1")
+	rep := &Report{Job: "1", Rev: "abc", Benchmark: "json", Runs: []Run{{
+		Procs:   4,
+		Metrics: map[string]uint64{"time": 100, "latency-99.9": 5},
+		Files:   map[string][]byte{"cpuprof": []byte("profile")},
+	}}}
+	if err := c.Report(rep); err != nil {
+		t.Fatal(err)
+	}
+	testBackend.mu.Lock()
+	got := testBackend.reports[len(testBackend.reports)-1]
+	testBackend.mu.Unlock()
+	if got.Job != "1" || len(got.Runs) != 1 || got.Runs[0].Metrics["time"] != 100 || string(got.Runs[0].Files["cpuprof"]) != "profile" {
+		t.Errorf("backend got report %+v", got)
+	}
+
+	for _, c := range []struct {
+		desc   string
+		body   string
+		status int
+	}{
+		{"malformed report", `{"Job":`, http.StatusBadRequest},
+		{"no job", `{"Rev":"abc","Benchmark":"json"}`, http.StatusBadRequest},
+		{"unknown benchmark", `{"Job":"1","Rev":"abc","Benchmark":"garbage"}`, http.StatusBadRequest},
+		{"bad procs", `{"Job":"1","Rev":"abc","Benchmark":"json","Runs":[{"Procs":0}]}`, http.StatusBadRequest},
+		{"bad metric name", `{"Job":"1","Rev":"abc","Benchmark":"json","Runs":[{"Procs":1,"Metrics":{"a\r\nb":1}}]}`, http.StatusBadRequest},
+		{"bad file name", `{"Job":"1","Rev":"abc","Benchmark":"json","Runs":[{"Procs":1,"Files":{"../x":""}}]}`, http.StatusBadRequest},
+		{"unknown job", `{"Job":"stale","Rev":"abc","Benchmark":"json"}`, http.StatusConflict},
+		{"failed job", `{"Job":"2","Rev":"abc","Benchmark":"json","Error":"build failed"}`, http.StatusOK},
+	} {
+		if resp := send(t, "POST", server+"/builder/result", "m1", "key1", []byte(c.body)); resp.StatusCode != c.status {
+			t.Errorf("%v: got %v, want %v", c.desc, resp.Status, c.status)
+		}
+	}
+	if resp := send(t, "GET", server+"/builder/result", "m1", "key1", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET of result: got %v, want 405", resp.Status)
+	}
+	data, _ := json.Marshal(&Report{Job: "1", Rev: "abc", Benchmark: "json"})
+	if resp := send(t, "POST", server+"/builder/result", "m3", "key1", data); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("report from unknown machine: got %v, want 403", resp.Status)
+	}
+}
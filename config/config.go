@@ -2,39 +2,99 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
+	"path"
+	"regexp"
+	"strings"
 )
 
 type HostConfig struct {
-	Addr string
+	Addr     string
+	URL      string // external URL of goperfd, used in notifications
+	Dir      string // data directory
+	Compress bool   // compress stored artifacts
+	SMTP     SMTPConfig
+}
+
+// SMTPConfig describes the mail server used to send notifications.
+type SMTPConfig struct {
+	Addr     string // host:port
+	From     string
+	User     string // optional, for PLAIN authentication
+	Password string
 }
 
 type ProjectConfig struct {
 	Name       string
+	Repo       string // path to a local git clone
+	Branch     string // branch to benchmark, HEAD by default
+	Start      string // oldest revision to benchmark
 	Benchmarks []Benchmark
 	Machines   []Machine
 	Metrics    []Metric
+	// Subscriptions receive notifications about detected changes.
+	Subscriptions []Subscription
+	Retention     Retention
 }
 
 type Benchmark struct {
-	Name string
-	Desc string
+	Name    string
+	Desc    string
+	Flags   []string // additional flags for the bench binary
+	Metrics []string // metrics the benchmark reports, optional
 }
 
 type Machine struct {
-	Name string
-	Desc string
-	Key  string
+	Name  string
+	Desc  string
+	Key   string
+	Procs []int    // GOMAXPROCS values to benchmark with, 1 by default
+	Flags []string // additional flags for the bench binary, e.g. -affinity
 }
 
 type Metric struct {
-	Name string
-	Desc string
+	Name      string
+	Desc      string
+	Unit      string  // ns, bytes or count (default)
+	Better    string  // lower (default) or higher
+	Threshold float64 // minimal meaningful relative change, e.g. 0.05 for 5%; 0 means the default
+}
+
+// Retention says which artifacts (GOPERF-FILE) survive garbage collection.
+// Metrics are never deleted.
+type Retention struct {
+	Revs    int    // keep artifacts of that many newest revisions, 0 keeps all artifacts
+	Tags    string // keep artifacts of revisions with tags that match the pattern (path.Match syntax), e.g. "go1*"
+	Changes bool   // keep artifacts of revisions around detected regressions
+}
+
+// Subscription is a recipient of change notifications.
+// Empty Benchmarks or Metrics match all benchmarks or metrics.
+type Subscription struct {
+	Email        string // email address
+	Webhook      string // URL that receives a JSON POST
+	Benchmarks   []string
+	Metrics      []string
+	Improvements bool // notify about improvements as well as regressions
 }
 
 var Host HostConfig
-var Project ProjectConfig
+
+// nameRe restricts project names, they are used in URLs.
+var nameRe = regexp.MustCompile("^[a-zA-Z0-9_.-]+$")
+
+// reservedNames are top-level URL paths that are not per-project (see ui/gc.go).
+var reservedNames = map[string]bool{
+	"gc": true,
+}
+
+// URLPath returns the path prefix of the project pages and endpoints, e.g. /Go.
+func (cfg *ProjectConfig) URLPath() string {
+	return "/" + cfg.Name
+}
 
 func Load(cfg interface{}, filename string) error {
 	f, err := os.Open(filename)
@@ -52,3 +112,150 @@ func Load(cfg interface{}, filename string) error {
 	}
 	return nil
 }
+
+// Validate checks the host config for errors.
+func (cfg *HostConfig) Validate() error {
+	var errs []string
+	if cfg.Addr == "" {
+		errs = append(errs, "no Addr")
+	}
+	if cfg.Dir == "" {
+		errs = append(errs, "no data Dir")
+	}
+	return makeError(errs)
+}
+
+// Validate checks the project config for errors.
+// All found problems are reported in a single error.
+func (cfg *ProjectConfig) Validate() error {
+	var errs []string
+	if cfg.Name == "" {
+		errs = append(errs, "no project Name")
+	} else if !nameRe.MatchString(cfg.Name) {
+		errs = append(errs, fmt.Sprintf("project Name '%v' can contain only letters, digits, '_', '-' and '.'", cfg.Name))
+	} else if strings.Trim(cfg.Name, ".") == "" || reservedNames[cfg.Name] {
+		errs = append(errs, fmt.Sprintf("project Name '%v' is reserved", cfg.Name))
+	}
+	if cfg.Repo == "" {
+		errs = append(errs, "no Repo")
+	}
+	metrics := make(map[string]bool)
+	for i, m := range cfg.Metrics {
+		switch {
+		case m.Name == "":
+			errs = append(errs, fmt.Sprintf("metric #%v has no name", i))
+		case metrics[m.Name]:
+			errs = append(errs, fmt.Sprintf("duplicate metric '%v'", m.Name))
+		}
+		switch m.Unit {
+		case "", UnitNs, UnitBytes, UnitCount:
+		default:
+			errs = append(errs, fmt.Sprintf("metric '%v' has unknown Unit '%v'", m.Name, m.Unit))
+		}
+		switch m.Better {
+		case "", BetterLower, BetterHigher:
+		default:
+			errs = append(errs, fmt.Sprintf("metric '%v' has bad Better value '%v', want lower or higher", m.Name, m.Better))
+		}
+		if m.Threshold < 0 || m.Threshold >= 1 {
+			errs = append(errs, fmt.Sprintf("metric '%v' has bad Threshold %v, want a fraction in [0, 1)", m.Name, m.Threshold))
+		}
+		metrics[m.Name] = true
+	}
+	benchmarks := make(map[string]bool)
+	for i, b := range cfg.Benchmarks {
+		switch {
+		case b.Name == "":
+			errs = append(errs, fmt.Sprintf("benchmark #%v has no name", i))
+		case benchmarks[b.Name]:
+			errs = append(errs, fmt.Sprintf("duplicate benchmark '%v'", b.Name))
+		case strings.ContainsAny(b.Name, ",*?["):
+			errs = append(errs, fmt.Sprintf("benchmark '%v' is not a single benchmark", b.Name))
+		}
+		benchmarks[b.Name] = true
+		for _, m := range b.Metrics {
+			if len(cfg.Metrics) != 0 && !metrics[m] {
+				errs = append(errs, fmt.Sprintf("benchmark '%v' reports unknown metric '%v'", b.Name, m))
+			}
+		}
+	}
+	if unreported, undeclared := cfg.unreportedMetrics(); len(undeclared) == 0 {
+		for _, m := range unreported {
+			errs = append(errs, fmt.Sprintf("metric '%v' is not reported by any benchmark", m))
+		}
+	} else if len(unreported) != 0 {
+		log.Printf("config: project %v: metrics %v are not declared by any benchmark; not an error, because benchmarks %v do not list Metrics",
+			cfg.Name, strings.Join(unreported, ", "), strings.Join(undeclared, ", "))
+	}
+	machines := make(map[string]bool)
+	for i, m := range cfg.Machines {
+		switch {
+		case m.Name == "":
+			errs = append(errs, fmt.Sprintf("machine #%v has no name", i))
+		case machines[m.Name]:
+			errs = append(errs, fmt.Sprintf("duplicate machine '%v'", m.Name))
+		}
+		machines[m.Name] = true
+		if m.Key == "" {
+			errs = append(errs, fmt.Sprintf("machine '%v' has no key", m.Name))
+		}
+		for _, p := range m.Procs {
+			if p <= 0 {
+				errs = append(errs, fmt.Sprintf("machine '%v' has bad GOMAXPROCS value %v", m.Name, p))
+			}
+		}
+	}
+	if cfg.Retention.Revs < 0 {
+		errs = append(errs, fmt.Sprintf("bad Retention.Revs value %v", cfg.Retention.Revs))
+	}
+	if _, err := path.Match(cfg.Retention.Tags, ""); err != nil {
+		errs = append(errs, fmt.Sprintf("bad Retention.Tags pattern '%v': %v", cfg.Retention.Tags, err))
+	}
+	for i, s := range cfg.Subscriptions {
+		if s.Email == "" && s.Webhook == "" {
+			errs = append(errs, fmt.Sprintf("subscription #%v has neither Email nor Webhook", i))
+		}
+		for _, b := range s.Benchmarks {
+			if !benchmarks[b] {
+				errs = append(errs, fmt.Sprintf("subscription #%v refers to unknown benchmark '%v'", i, b))
+			}
+		}
+		for _, m := range s.Metrics {
+			if len(cfg.Metrics) != 0 && !metrics[m] {
+				errs = append(errs, fmt.Sprintf("subscription #%v refers to unknown metric '%v'", i, m))
+			}
+		}
+	}
+	return makeError(errs)
+}
+
+// unreportedMetrics returns described metrics that are not listed by any
+// benchmark. Benchmarks without Metrics can report any metric, they are
+// returned as undeclared, and then the unreported metrics can still be reported.
+func (cfg *ProjectConfig) unreportedMetrics() (unreported, undeclared []string) {
+	if len(cfg.Benchmarks) == 0 {
+		return nil, nil
+	}
+	declared := make(map[string]bool)
+	for _, b := range cfg.Benchmarks {
+		if len(b.Metrics) == 0 {
+			undeclared = append(undeclared, b.Name)
+		}
+		for _, m := range b.Metrics {
+			declared[m] = true
+		}
+	}
+	for _, m := range cfg.Metrics {
+		if !declared[m.Name] {
+			unreported = append(unreported, m.Name)
+		}
+	}
+	return unreported, undeclared
+}
+
+func makeError(errs []string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%v", strings.Join(errs, "\n"))
+}
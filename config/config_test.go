@@ -0,0 +1,72 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProjectName(t *testing.T) {
+	for name, ok := range map[string]bool{
+		"Go":      true,
+		"go1.2":   true,
+		"a_b-c":   true,
+		"..a":     true,
+		"":        false,
+		".":       false,
+		"..":      false,
+		"...":     false,
+		"gc":      false,
+		"a/b":     false,
+		"a b":     false,
+		"a\nb":    false,
+		"gc.main": true,
+	} {
+		cfg := &ProjectConfig{Name: name, Repo: "https://example.com/repo"}
+		if err := cfg.Validate(); (err == nil) != ok {
+			t.Errorf("name '%v': got error %v, want ok=%v", name, err, ok)
+		}
+	}
+}
+
+func TestBenchmarkName(t *testing.T) {
+	for name, ok := range map[string]bool{
+		"json":                 true,
+		"http/keepalive=false": true,
+		"json,http":            false,
+		"*":                    false,
+		"h?tp":                 false,
+		"[jh]*":                false,
+	} {
+		cfg := &ProjectConfig{Name: "Go", Repo: "https://example.com/repo", Benchmarks: []Benchmark{{Name: name}}}
+		if err := cfg.Validate(); (err == nil) != ok {
+			t.Errorf("benchmark '%v': got error %v, want ok=%v", name, err, ok)
+		}
+	}
+}
+
+func TestUnreportedMetrics(t *testing.T) {
+	metrics := []Metric{{Name: "time"}, {Name: "rss"}, {Name: "latency-99"}}
+	for _, c := range []struct {
+		desc       string
+		benchmarks []Benchmark
+		unreported []string
+		undeclared []string
+		ok         bool
+	}{
+		{"all reported", []Benchmark{{Name: "json", Metrics: []string{"time", "rss"}}, {Name: "http", Metrics: []string{"time", "latency-99"}}}, nil, nil, true},
+		{"unreported", []Benchmark{{Name: "json", Metrics: []string{"time"}}, {Name: "http", Metrics: []string{"time", "latency-99"}}}, []string{"rss"}, nil, false},
+		// http can report anything, so rss and latency-99 are not errors.
+		{"partly declared", []Benchmark{{Name: "json", Metrics: []string{"time"}}, {Name: "http"}}, []string{"rss", "latency-99"}, []string{"http"}, true},
+		{"none declared", []Benchmark{{Name: "json"}, {Name: "http"}}, []string{"time", "rss", "latency-99"}, []string{"json", "http"}, true},
+		{"no benchmarks", nil, nil, nil, true},
+	} {
+		cfg := &ProjectConfig{Name: "Go", Repo: "https://example.com/repo", Benchmarks: c.benchmarks, Metrics: metrics}
+		unreported, undeclared := cfg.unreportedMetrics()
+		if strings.Join(unreported, ",") != strings.Join(c.unreported, ",") || strings.Join(undeclared, ",") != strings.Join(c.undeclared, ",") {
+			t.Errorf("%v: got unreported %v, undeclared %v, want %v, %v", c.desc, unreported, undeclared, c.unreported, c.undeclared)
+		}
+		if err := cfg.Validate(); (err == nil) != c.ok {
+			t.Errorf("%v: got error %v, want ok=%v", c.desc, err, c.ok)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"math"
+)
+
+// Metric units.
+const (
+	UnitNs    = "ns"
+	UnitBytes = "bytes"
+	UnitCount = "count"
+)
+
+// Metric directions.
+const (
+	BetterLower  = "lower"
+	BetterHigher = "higher"
+)
+
+// Metric returns the description of the metric.
+// Metrics that are not described in the config are counts where lower is better.
+func (cfg *ProjectConfig) Metric(name string) *Metric {
+	for i := range cfg.Metrics {
+		if cfg.Metrics[i].Name == name {
+			return &cfg.Metrics[i]
+		}
+	}
+	return &Metric{Name: name}
+}
+
+// Worse says whether the change of the metric from old to new is for the worse.
+// The magnitude of the change is not taken into account.
+func (m *Metric) Worse(old, new float64) bool {
+	if m.Better == BetterHigher {
+		return new < old
+	}
+	return new > old
+}
+
+type scale struct {
+	div    float64
+	suffix string
+}
+
+var (
+	nsScales    = []scale{{1e9, "s"}, {1e6, "ms"}, {1e3, "µs"}, {1, "ns"}}
+	bytesScales = []scale{{1 << 30, "GB"}, {1 << 20, "MB"}, {1 << 10, "KB"}, {1, "B"}}
+)
+
+// Format formats a value of the metric for humans, e.g. 1.25ms or 64MB.
+func (m *Metric) Format(v float64) string {
+	var scales []scale
+	switch m.Unit {
+	case UnitNs:
+		scales = nsScales
+	case UnitBytes:
+		scales = bytesScales
+	default:
+		if math.Abs(v) >= 100 {
+			return fmt.Sprintf("%.0f", v)
+		}
+		return fmt.Sprintf("%.3g", v)
+	}
+	s := scales[len(scales)-1]
+	for _, s1 := range scales {
+		if math.Abs(v) >= s1.div {
+			s = s1
+			break
+		}
+	}
+	v /= s.div
+	switch {
+	case s.div == 1, math.Abs(v) >= 100:
+		return fmt.Sprintf("%.0f%v", v, s.suffix)
+	default:
+		return fmt.Sprintf("%.3g%v", v, s.suffix)
+	}
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ProjectFile is a project config that is reloaded when the file changes
+// or goperfd receives SIGHUP. A reload replaces the whole config at once,
+// so users must take a snapshot with Get and use it for the duration
+// of a request.
+type ProjectFile struct {
+	Filename string
+
+	mu    sync.Mutex // serializes reloads
+	mtime time.Time
+	cur   atomic.Value // *ProjectConfig
+}
+
+// LoadProject loads and validates the project config.
+func LoadProject(filename string) (*ProjectFile, error) {
+	f := &ProjectFile{Filename: filename}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Get returns the current config snapshot. It must not be modified.
+func (f *ProjectFile) Get() *ProjectConfig {
+	cfg, _ := f.cur.Load().(*ProjectConfig)
+	if cfg == nil {
+		return new(ProjectConfig)
+	}
+	return cfg
+}
+
+// Set replaces the config.
+func (f *ProjectFile) Set(cfg *ProjectConfig) {
+	f.cur.Store(cfg)
+}
+
+// Reload re-reads the config file. If the new config is invalid,
+// the current config is left intact.
+func (f *ProjectFile) Reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	st, err := os.Stat(f.Filename)
+	if err != nil {
+		return err
+	}
+	cfg := new(ProjectConfig)
+	if err := Load(cfg, f.Filename); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if old, _ := f.cur.Load().(*ProjectConfig); old != nil {
+		if cfg.Name != old.Name || cfg.Repo != old.Repo || cfg.Branch != old.Branch {
+			return fmt.Errorf("changing project Name, Repo or Branch requires restart")
+		}
+	}
+	f.mtime = st.ModTime()
+	f.cur.Store(cfg)
+	return nil
+}
+
+// Watch reloads the config on SIGHUP or when the file modification time changes.
+func (f *ProjectFile) Watch(period time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(period)
+	for {
+		select {
+		case <-hup:
+		case <-ticker.C:
+			st, err := os.Stat(f.Filename)
+			f.mu.Lock()
+			changed := err == nil && !st.ModTime().Equal(f.mtime)
+			f.mu.Unlock()
+			if !changed {
+				continue
+			}
+		}
+		if err := f.Reload(); err != nil {
+			log.Printf("config: failed to reload '%v', keeping the old config:\n%v", f.Filename, err)
+			// Do not retry until the file changes again.
+			if st, err := os.Stat(f.Filename); err == nil {
+				f.mu.Lock()
+				f.mtime = st.ModTime()
+				f.mu.Unlock()
+			}
+			continue
+		}
+		log.Printf("config: reloaded '%v'", f.Filename)
+	}
+}
@@ -1,5 +1,176 @@
+// Package db stores benchmark results.
 package db
 
+import (
+	"time"
+)
+
+// Result is a single metric value produced by a benchmark run.
 type Result struct {
-	
+	Project   string
+	Rev       string
+	Benchmark string
+	Machine   string
+	Metric    string
+	Procs     int // GOMAXPROCS
+	Value     uint64
+	Time      time.Time // when the result was received
+}
+
+// File is an artifact (GOPERF-FILE) produced by a benchmark run,
+// e.g. a cpu profile or a perf report.
+type File struct {
+	Project   string
+	Rev       string
+	Benchmark string
+	Machine   string
+	Procs     int
+	Name      string
+	Hash      string // blob hash of the contents
+	Size      int64
+	Time      time.Time
+}
+
+// Change is a statistically significant shift in a series of results.
+type Change struct {
+	Id         string
+	Project    string
+	Benchmark  string
+	Machine    string
+	Metric     string
+	Procs      int
+	Rev        string    // first benchmarked revision after the shift
+	Prev       string    // last benchmarked revision before the shift
+	Old        float64   // median before the shift
+	New        float64   // median after the shift
+	P          float64   // p-value
+	Regression bool      // the change is for the worse
+	Culprit    string    // the revision that caused the change, set once Prev is the parent of Rev
+	Time       time.Time // when the change was detected
+}
+
+// Query selects a subset of results. Empty fields match any value.
+type Query struct {
+	Project   string
+	Rev       string
+	Benchmark string
+	Machine   string
+	Metric    string
+	Procs     int
+}
+
+// Store is a persistent collection of results.
+// A result with the same project, revision, benchmark, machine, metric
+// and GOMAXPROCS as an existing one replaces it.
+// The store keeps copies of added records and returns copies,
+// so callers are free to modify them.
+type Store interface {
+	Add(results []*Result) error
+	Select(q *Query) ([]*Result, error)
+	// Series returns values of the metric for the benchmark on the machine
+	// sorted by revision id. Revision order is defined by the repository
+	// (see regress.Order).
+	Series(project, benchmark, metric, machine string, procs int) ([]*Result, error)
+	// Revision returns all results for the revision.
+	Revision(project, rev string) ([]*Result, error)
+	// AddFile adds an artifact or replaces the artifact with the same key.
+	// Contents of the artifact are stored separately in a blob store.
+	AddFile(f *File) error
+	// Files returns all artifacts for the revision.
+	Files(project, rev string) ([]*File, error)
+	// AllFiles returns all artifacts in all projects.
+	AllFiles() ([]*File, error)
+	// DeleteFiles deletes artifacts. Their contents are not affected.
+	DeleteFiles(files []*File) error
+	// AddChange adds a change or replaces the change with the same id.
+	AddChange(c *Change) error
+	// Changes returns all changes in the project, newest first.
+	Changes(project string) ([]*Change, error)
+	// Compact reclaims space taken by replaced and deleted records.
+	Compact() error
+	Close() error
+}
+
+func (q *Query) match(r *Result) bool {
+	return (q.Project == "" || q.Project == r.Project) &&
+		(q.Rev == "" || q.Rev == r.Rev) &&
+		(q.Benchmark == "" || q.Benchmark == r.Benchmark) &&
+		(q.Machine == "" || q.Machine == r.Machine) &&
+		(q.Metric == "" || q.Metric == r.Metric) &&
+		(q.Procs == 0 || q.Procs == r.Procs)
+}
+
+type seriesKey struct {
+	Project   string
+	Benchmark string
+	Machine   string
+	Metric    string
+	Procs     int
+}
+
+type fileKey struct {
+	Project   string
+	Rev       string
+	Benchmark string
+	Machine   string
+	Procs     int
+	Name      string
+}
+
+type revKey struct {
+	Project string
+	Rev     string
+}
+
+func keys(r *Result) (seriesKey, revKey) {
+	return seriesKey{r.Project, r.Benchmark, r.Machine, r.Metric, r.Procs}, revKey{r.Project, r.Rev}
+}
+
+// resultSlice sorts results by benchmark, machine, metric, GOMAXPROCS and revision.
+type resultSlice []*Result
+
+func (p resultSlice) Len() int      { return len(p) }
+func (p resultSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p resultSlice) Less(i, j int) bool {
+	a, b := p[i], p[j]
+	switch {
+	case a.Project != b.Project:
+		return a.Project < b.Project
+	case a.Benchmark != b.Benchmark:
+		return a.Benchmark < b.Benchmark
+	case a.Machine != b.Machine:
+		return a.Machine < b.Machine
+	case a.Metric != b.Metric:
+		return a.Metric < b.Metric
+	case a.Procs != b.Procs:
+		return a.Procs < b.Procs
+	default:
+		return a.Rev < b.Rev
+	}
+}
+
+// changeSlice sorts changes by detection time, newest first.
+type changeSlice []*Change
+
+func (p changeSlice) Len() int           { return len(p) }
+func (p changeSlice) Less(i, j int) bool { return p[i].Time.After(p[j].Time) }
+func (p changeSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// fileSlice sorts files by benchmark, machine, GOMAXPROCS and name.
+type fileSlice []*File
+
+func (p fileSlice) Len() int      { return len(p) }
+func (p fileSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p fileSlice) Less(i, j int) bool {
+	a, b := p[i], p[j]
+	switch {
+	case a.Benchmark != b.Benchmark:
+		return a.Benchmark < b.Benchmark
+	case a.Machine != b.Machine:
+		return a.Machine < b.Machine
+	case a.Procs != b.Procs:
+		return a.Procs < b.Procs
+	default:
+		return a.Name < b.Name
+	}
 }
@@ -0,0 +1,347 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// fileStore keeps all results in memory and persists them in an append-only log.
+// Each line of the log is a JSON-encoded record.
+type fileStore struct {
+	mu      sync.RWMutex
+	dir     string
+	f       *os.File
+	series  map[seriesKey]map[string]*Result
+	revs    map[revKey]map[seriesKey]*Result
+	files   map[fileKey]*File
+	revFile map[revKey]map[fileKey]*File // files by revision, for Files
+	changes map[string]*Change
+}
+
+type record struct {
+	Result      *Result `json:",omitempty"`
+	File        *File   `json:",omitempty"`
+	Change      *Change `json:",omitempty"`
+	DeletedFile *File   `json:",omitempty"` // tombstone
+}
+
+const logName = "results.log"
+
+// Open opens or creates a file-backed store in dir.
+func Open(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, logName), os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	s := &fileStore{
+		dir:     dir,
+		f:       f,
+		series:  make(map[seriesKey]map[string]*Result),
+		revs:    make(map[revKey]map[seriesKey]*Result),
+		files:   make(map[fileKey]*File),
+		revFile: make(map[revKey]map[fileKey]*File),
+		changes: make(map[string]*Change),
+	}
+	if err := s.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load replays the log. A partially written last record (e.g. after a crash) is discarded.
+func (s *fileStore) load() error {
+	r := bufio.NewReader(s.f)
+	off := int64(0)
+	for {
+		ln, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(ln) != 0 {
+				log.Printf("db: discarding truncated record at offset %v", off)
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var rec record
+		if err := json.Unmarshal(ln, &rec); err != nil {
+			if _, err1 := r.Peek(1); err1 == io.EOF {
+				log.Printf("db: discarding torn last record at offset %v: %v", off, err)
+				break
+			}
+			return fmt.Errorf("corrupted record at offset %v: %v", off, err)
+		}
+		s.apply(&rec)
+		off += int64(len(ln))
+	}
+	if err := s.f.Truncate(off); err != nil {
+		return err
+	}
+	_, err := s.f.Seek(off, 0)
+	return err
+}
+
+func (s *fileStore) apply(rec *record) {
+	if res := rec.Result; res != nil {
+		sk, rk := keys(res)
+		if s.series[sk] == nil {
+			s.series[sk] = make(map[string]*Result)
+		}
+		s.series[sk][res.Rev] = res
+		if s.revs[rk] == nil {
+			s.revs[rk] = make(map[seriesKey]*Result)
+		}
+		s.revs[rk][sk] = res
+	}
+	if f := rec.File; f != nil {
+		fk, rk := fileKey{f.Project, f.Rev, f.Benchmark, f.Machine, f.Procs, f.Name}, revKey{f.Project, f.Rev}
+		s.files[fk] = f
+		if s.revFile[rk] == nil {
+			s.revFile[rk] = make(map[fileKey]*File)
+		}
+		s.revFile[rk][fk] = f
+	}
+	if f := rec.DeletedFile; f != nil {
+		fk, rk := fileKey{f.Project, f.Rev, f.Benchmark, f.Machine, f.Procs, f.Name}, revKey{f.Project, f.Rev}
+		delete(s.files, fk)
+		delete(s.revFile[rk], fk)
+		if len(s.revFile[rk]) == 0 {
+			delete(s.revFile, rk)
+		}
+	}
+	if c := rec.Change; c != nil {
+		s.changes[c.Id] = c
+	}
+}
+
+// write appends records to the log. If the write fails, the log is truncated
+// back to the last complete record, so that it can still be loaded.
+func (s *fileStore) write(recs []*record) error {
+	var buf bytes.Buffer
+	for _, rec := range recs {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	off, err := s.f.Seek(0, os.SEEK_CUR)
+	if err != nil {
+		return err
+	}
+	if _, err = s.f.Write(buf.Bytes()); err == nil {
+		err = s.f.Sync()
+	}
+	if err != nil {
+		if err1 := s.f.Truncate(off); err1 != nil {
+			log.Printf("db: failed to truncate log after failed write: %v", err1)
+		} else if _, err1 := s.f.Seek(off, os.SEEK_SET); err1 != nil {
+			log.Printf("db: failed to seek log after failed write: %v", err1)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) Add(results []*Result) error {
+	recs := make([]*record, len(results))
+	for i, res := range results {
+		if res.Project == "" || res.Rev == "" || res.Benchmark == "" || res.Machine == "" || res.Metric == "" {
+			return fmt.Errorf("incomplete result %+v", res)
+		}
+		c := *res
+		recs[i] = &record{Result: &c}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.write(recs); err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		s.apply(rec)
+	}
+	return nil
+}
+
+func (s *fileStore) Select(q *Query) ([]*Result, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var res []*Result
+	if q.Project != "" && q.Rev != "" {
+		for _, r := range s.revs[revKey{q.Project, q.Rev}] {
+			if q.match(r) {
+				c := *r
+				res = append(res, &c)
+			}
+		}
+	} else {
+		for _, series := range s.series {
+			for _, r := range series {
+				if q.match(r) {
+					c := *r
+					res = append(res, &c)
+				}
+			}
+		}
+	}
+	sort.Sort(resultSlice(res))
+	return res, nil
+}
+
+func (s *fileStore) Series(project, benchmark, metric, machine string, procs int) ([]*Result, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var res []*Result
+	for _, r := range s.series[seriesKey{project, benchmark, machine, metric, procs}] {
+		c := *r
+		res = append(res, &c)
+	}
+	sort.Sort(resultSlice(res))
+	return res, nil
+}
+
+func (s *fileStore) Revision(project, rev string) ([]*Result, error) {
+	return s.Select(&Query{Project: project, Rev: rev})
+}
+
+func (s *fileStore) AddFile(f *File) error {
+	if f.Project == "" || f.Rev == "" || f.Benchmark == "" || f.Machine == "" || f.Name == "" || f.Hash == "" {
+		return fmt.Errorf("incomplete file %+v", f)
+	}
+	c := *f
+	rec := &record{File: &c}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.write([]*record{rec}); err != nil {
+		return err
+	}
+	s.apply(rec)
+	return nil
+}
+
+func (s *fileStore) Files(project, rev string) ([]*File, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var res []*File
+	for _, f := range s.revFile[revKey{project, rev}] {
+		c := *f
+		res = append(res, &c)
+	}
+	sort.Sort(fileSlice(res))
+	return res, nil
+}
+
+func (s *fileStore) AllFiles() ([]*File, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var res []*File
+	for _, f := range s.files {
+		c := *f
+		res = append(res, &c)
+	}
+	sort.Sort(fileSlice(res))
+	return res, nil
+}
+
+func (s *fileStore) DeleteFiles(files []*File) error {
+	recs := make([]*record, len(files))
+	for i, f := range files {
+		recs[i] = &record{DeletedFile: f}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.write(recs); err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		s.apply(rec)
+	}
+	return nil
+}
+
+func (s *fileStore) AddChange(c *Change) error {
+	if c.Id == "" {
+		return fmt.Errorf("change without id")
+	}
+	cc := *c
+	rec := &record{Change: &cc}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.write([]*record{rec}); err != nil {
+		return err
+	}
+	s.apply(rec)
+	return nil
+}
+
+func (s *fileStore) Changes(project string) ([]*Change, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var res []*Change
+	for _, c := range s.changes {
+		if c.Project == project {
+			cc := *c
+			res = append(res, &cc)
+		}
+	}
+	sort.Sort(changeSlice(res))
+	return res, nil
+}
+
+// Compact rewrites the log with the current contents of the store.
+// The new log is written to a temp file and renamed over the old one,
+// so a crash leaves either the old or the new log.
+func (s *fileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var recs []*record
+	for _, series := range s.series {
+		for _, r := range series {
+			recs = append(recs, &record{Result: r})
+		}
+	}
+	for _, f := range s.files {
+		recs = append(recs, &record{File: f})
+	}
+	for _, c := range s.changes {
+		recs = append(recs, &record{Change: c})
+	}
+	name := filepath.Join(s.dir, logName)
+	f, err := os.OpenFile(name+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	old := s.f
+	s.f = f
+	err = s.write(recs)
+	if err == nil {
+		err = os.Rename(name+".tmp", name)
+	}
+	if err != nil {
+		s.f = old
+		f.Close()
+		os.Remove(name + ".tmp")
+		return err
+	}
+	old.Close()
+	return nil
+}
+
+func (s *fileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
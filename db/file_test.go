@@ -0,0 +1,190 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testResult(rev string, value uint64) *Result {
+	return &Result{Project: "p", Rev: rev, Benchmark: "json", Machine: "m", Metric: "time", Procs: 1, Value: value}
+}
+
+func openTestStore(t *testing.T, dir string) Store {
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func appendLog(t *testing.T, dir, data string) {
+	f, err := os.OpenFile(filepath.Join(dir, logName), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTornRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goperfd-db-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := openTestStore(t, dir)
+	if err := s.Add([]*Result{testResult("a", 1), testResult("b", 2)}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// A record cut by a crash, with or without the terminating newline.
+	for _, torn := range []string{`{"Result":{"Project":"p","Rev":"c"`, `{"Result":{"Project":"p","Rev":"c"` + "\n"} {
+		appendLog(t, dir, torn)
+		s = openTestStore(t, dir)
+		if err := s.Add([]*Result{testResult("d", 4)}); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+		s = openTestStore(t, dir)
+		res, err := s.Select(&Query{Project: "p"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 3 {
+			t.Fatalf("got %v results after a torn record, want 3", len(res))
+		}
+		s.Close()
+	}
+
+	// Corruption in the middle of the log is an error.
+	data, err := ioutil.ReadFile(filepath.Join(dir, logName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, logName), append([]byte("garbage\n"), data...), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := Open(dir); err == nil {
+		s.Close()
+		t.Fatalf("opened a log corrupted in the middle")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goperfd-db-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := openTestStore(t, dir)
+	file := func(rev, name string) *File {
+		return &File{Project: "p", Rev: rev, Benchmark: "json", Machine: "m", Procs: 1, Name: name, Hash: rev + name}
+	}
+	for _, f := range []*File{file("a", "cpuprof"), file("a", "memprof"), file("b", "cpuprof"), file("a", "cpuprof")} {
+		if err := s.AddFile(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.DeleteFiles([]*File{file("b", "cpuprof")}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		files, err := s.Files("p", "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 2 || files[0].Name != "cpuprof" || files[1].Name != "memprof" {
+			t.Errorf("got %v files at a, want cpuprof and memprof", len(files))
+		}
+		if files, _ := s.Files("p", "b"); len(files) != 0 {
+			t.Errorf("got %v files at b after delete", len(files))
+		}
+		if files, _ := s.Files("q", "a"); len(files) != 0 {
+			t.Errorf("got %v files in another project", len(files))
+		}
+		// The index is rebuilt from the log.
+		s.Close()
+		s = openTestStore(t, dir)
+	}
+	s.Close()
+}
+
+func TestCopies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goperfd-db-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := openTestStore(t, dir)
+	defer s.Close()
+	res := testResult("a", 1)
+	f := &File{Project: "p", Rev: "a", Benchmark: "json", Machine: "m", Procs: 1, Name: "cpuprof", Hash: "h"}
+	c := &Change{Id: "c", Project: "p", Rev: "a"}
+	if err := s.Add([]*Result{res}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddFile(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddChange(c); err != nil {
+		t.Fatal(err)
+	}
+	// Modifications of added records do not affect the store.
+	res.Value, f.Hash, c.Culprit = 2, "h2", "x"
+	// Neither do modifications of returned records.
+	all, _ := s.Select(&Query{Project: "p"})
+	rev, _ := s.Revision("p", "a")
+	series, _ := s.Series("p", "json", "time", "m", 1)
+	files, _ := s.Files("p", "a")
+	allFiles, _ := s.AllFiles()
+	changes, _ := s.Changes("p")
+	if len(all) != 1 || len(rev) != 1 || len(series) != 1 || len(files) != 1 || len(allFiles) != 1 || len(changes) != 1 {
+		t.Fatalf("got %v, %v, %v, %v, %v, %v records", len(all), len(rev), len(series), len(files), len(allFiles), len(changes))
+	}
+	all[0].Value, rev[0].Value, series[0].Value = 3, 4, 5
+	files[0].Hash, allFiles[0].Hash, changes[0].Culprit = "h3", "h4", "y"
+	all, _ = s.Select(&Query{Project: "p", Rev: "a"})
+	files, _ = s.AllFiles()
+	changes, _ = s.Changes("p")
+	if all[0].Value != 1 || files[0].Hash != "h" || changes[0].Culprit != "" {
+		t.Errorf("records were modified: %+v, %+v, %+v", all[0], files[0], changes[0])
+	}
+}
+
+func TestSeriesOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goperfd-db-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := openTestStore(t, dir)
+	defer s.Close()
+	revs := []string{"e", "b", "d", "a", "c", "f"}
+	var results []*Result
+	for i, rev := range revs {
+		results = append(results, testResult(rev, uint64(i)))
+	}
+	other := testResult("a", 100)
+	other.Procs = 4
+	if err := s.Add(append(results, other)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		series, err := s.Series("p", "json", "time", "m", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		for _, r := range series {
+			got += r.Rev
+		}
+		if got != "abcdef" {
+			t.Fatalf("got series %v, want abcdef", got)
+		}
+	}
+}
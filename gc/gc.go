@@ -0,0 +1,196 @@
+// Package gc deletes artifacts according to retention policies of the projects
+// and reclaims storage taken by blobs that are no longer referenced.
+//
+// Artifacts of a project are deleted only if the project has a non-zero
+// Retention.Revs. Artifacts of the newest Retention.Revs revisions,
+// of tagged revisions that match Retention.Tags and, if Retention.Changes
+// is set, of revisions around detected regressions are kept.
+// Artifacts of revisions that are not in the repository (e.g. after the branch
+// was rewritten) and of unknown projects are kept as well. Metrics are never deleted.
+package gc
+
+import (
+	"log"
+	"path"
+	"sync"
+	"time"
+
+	"code.google.com/p/goperfd/blob"
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/repo"
+)
+
+// Grace is the min age of an unreferenced blob that can be deleted.
+// Builder uploads are stored in the blob store before they are referenced,
+// storing existing data refreshes the age of the blob.
+const Grace = time.Hour
+
+type Project struct {
+	Config *config.ProjectFile
+	Repo   *repo.Repo
+}
+
+// Collector runs garbage collection over all projects that share a store.
+type Collector struct {
+	store    db.Store
+	blobs    *blob.Store
+	projects []*Project
+
+	mu      sync.Mutex // serializes runs
+	last    *Report    // the last run that was not a dry run
+	preview *Report    // the last dry run after the last run
+}
+
+// Report describes what a run has deleted, or would delete if it is a dry run.
+type Report struct {
+	Time      time.Time
+	DryRun    bool
+	Files     []*db.File // deleted artifacts
+	FileBytes int64      // total size of deleted artifacts
+	Kept      int        // number of remaining artifacts
+	Blobs     int        // number of deleted blobs
+	BlobBytes int64      // storage taken by deleted blobs
+}
+
+func New(s db.Store, b *blob.Store, projects []*Project) *Collector {
+	return &Collector{store: s, blobs: b, projects: projects}
+}
+
+// Poll runs garbage collection every period.
+func (c *Collector) Poll(period time.Duration) {
+	for {
+		rep, err := c.Run(false)
+		if err != nil {
+			log.Printf("gc: failed: %v", err)
+		} else {
+			log.Printf("gc: deleted %v artifacts (%v bytes) and %v blobs (%v bytes), %v artifacts left",
+				len(rep.Files), rep.FileBytes, rep.Blobs, rep.BlobBytes, rep.Kept)
+		}
+		time.Sleep(period)
+	}
+}
+
+// Last returns the report of the last run that was not a dry run, or nil.
+func (c *Collector) Last() *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// Preview returns the report of the last dry run, or nil if there was
+// no dry run since the last run.
+func (c *Collector) Preview() *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.preview
+}
+
+// Run runs garbage collection once. If dryRun is set, nothing is deleted.
+func (c *Collector) Run(dryRun bool) (*Report, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rep := &Report{Time: time.Now(), DryRun: dryRun}
+	expired := make(map[string]map[string]bool)
+	for _, p := range c.projects {
+		cfg := p.Config.Get()
+		revs, err := c.expired(cfg, p.Repo)
+		if err != nil {
+			return nil, err
+		}
+		expired[cfg.Name] = revs
+	}
+	files, err := c.store.AllFiles()
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool)
+	for _, f := range files {
+		if expired[f.Project][f.Rev] {
+			rep.Files = append(rep.Files, f)
+			rep.FileBytes += f.Size
+			continue
+		}
+		referenced[f.Hash] = true
+		rep.Kept++
+	}
+	if !dryRun && len(rep.Files) != 0 {
+		if err := c.store.DeleteFiles(rep.Files); err != nil {
+			return nil, err
+		}
+	}
+	blobs, err := c.blobs.List()
+	if err != nil {
+		return nil, err
+	}
+	cutoff := rep.Time.Add(-Grace)
+	for _, b := range blobs {
+		if referenced[b.Hash] || !b.Time.Before(cutoff) {
+			continue
+		}
+		if !dryRun {
+			// The blob may have been stored again since it was listed.
+			deleted, err := c.blobs.DeleteOlder(b.Hash, cutoff)
+			if err != nil {
+				return nil, err
+			}
+			if !deleted {
+				continue
+			}
+		}
+		rep.Blobs++
+		rep.BlobBytes += b.Size
+	}
+	if !dryRun {
+		if len(rep.Files) != 0 {
+			if err := c.store.Compact(); err != nil {
+				return nil, err
+			}
+		}
+		c.last = rep
+		c.preview = nil
+	} else {
+		c.preview = rep
+	}
+	return rep, nil
+}
+
+// expired returns revisions of the project whose artifacts can be deleted.
+func (c *Collector) expired(cfg *config.ProjectConfig, r *repo.Repo) (map[string]bool, error) {
+	policy := cfg.Retention
+	revs := r.Revs()
+	if policy.Revs == 0 || len(revs) <= policy.Revs {
+		return nil, nil
+	}
+	res := make(map[string]bool)
+	for _, rev := range revs[:len(revs)-policy.Revs] {
+		res[rev.Id] = true
+	}
+	if policy.Tags != "" {
+		tags, err := r.Tags()
+		if err != nil {
+			return nil, err
+		}
+		for id, names := range tags {
+			for _, name := range names {
+				if ok, _ := path.Match(policy.Tags, name); ok {
+					delete(res, id)
+				}
+			}
+		}
+	}
+	if policy.Changes {
+		changes, err := c.store.Changes(cfg.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ch := range changes {
+			if ch.Regression {
+				delete(res, ch.Prev)
+				delete(res, ch.Rev)
+				delete(res, ch.Culprit)
+			}
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,264 @@
+package gc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/goperfd/blob"
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/repo"
+)
+
+type testEnv struct {
+	c       *Collector
+	store   db.Store
+	blobs   *blob.Store
+	blobDir string
+	revs    []*repo.Rev
+	shared  string // hash of the blob that every revision references
+	dir     string
+}
+
+// newTestEnv creates a collector over a git repository with n linear commits,
+// commit #2 is tagged go1. Every revision has a cpuprof artifact with its own
+// contents and a shared artifact with the same contents in all revisions.
+// All blobs are older than Grace.
+func newTestEnv(t *testing.T, n int, policy config.Retention) *testEnv {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "goperfd-gc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = filepath.Join(dir, "repo")
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Gopher", "GIT_AUTHOR_EMAIL=gopher@example.com",
+			"GIT_COMMITTER_NAME=Gopher", "GIT_COMMITTER_EMAIL=gopher@example.com", "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	os.Mkdir(filepath.Join(dir, "repo"), 0750)
+	git("init", "--quiet")
+	for i := 0; i < n; i++ {
+		git("commit", "--quiet", "--allow-empty", "-m", fmt.Sprintf("commit %v", i))
+		if i == 2 {
+			git("tag", "go1")
+		}
+	}
+	r, err := repo.Open(filepath.Join(dir, "repo"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := db.Open(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &testEnv{store: store, blobDir: filepath.Join(dir, "blobs"), revs: r.Revs(), dir: dir}
+	env.blobs, err = blob.Open(env.blobDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rev := range env.revs {
+		for name, data := range map[string]string{"cpuprof": "profile of " + rev.Id, "sections": "shared"} {
+			hash, err := env.blobs.Put([]byte(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name == "sections" {
+				env.shared = hash
+			}
+			f := &db.File{Project: "test", Rev: rev.Id, Benchmark: "json", Machine: "m1", Procs: 1,
+				Name: name, Hash: hash, Size: int64(len(data)), Time: time.Now()}
+			if err := store.AddFile(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	env.age(2 * Grace)
+	project := new(config.ProjectFile)
+	project.Set(&config.ProjectConfig{Name: "test", Retention: policy})
+	env.c = New(store, env.blobs, []*Project{{Config: project, Repo: r}})
+	return env
+}
+
+// age sets modification time of all blobs to d ago.
+func (env *testEnv) age(d time.Duration) {
+	t := time.Now().Add(-d)
+	filepath.Walk(env.blobDir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			os.Chtimes(path, t, t)
+		}
+		return err
+	})
+}
+
+func (env *testEnv) close() {
+	env.store.Close()
+	os.RemoveAll(env.dir)
+}
+
+// keptRevs returns indexes of revisions that still have artifacts.
+func (env *testEnv) keptRevs(t *testing.T) []int {
+	files, err := env.store.AllFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[int]bool)
+	var res []int
+	for _, f := range files {
+		for i, rev := range env.revs {
+			if rev.Id == f.Rev && !seen[i] {
+				seen[i] = true
+				res = append(res, i)
+			}
+		}
+	}
+	sort.Ints(res)
+	return res
+}
+
+func TestKeepLast(t *testing.T) {
+	env := newTestEnv(t, 8, config.Retention{Revs: 3})
+	defer env.close()
+	rep, err := env.c.Run(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Revisions #0..#4 expire, every one has 2 artifacts,
+	// their profiles are not referenced once the artifacts are deleted.
+	if len(rep.Files) != 10 || rep.Kept != 6 || rep.Blobs != 5 {
+		t.Errorf("dry run: got %v files, %v kept, %v blobs", len(rep.Files), rep.Kept, rep.Blobs)
+	}
+	if got := fmt.Sprint(env.keptRevs(t)); got != "[0 1 2 3 4 5 6 7]" {
+		t.Errorf("dry run deleted artifacts, left %v", got)
+	}
+	if !env.blobs.Has(blob.Hash([]byte("profile of " + env.revs[0].Id))) {
+		t.Errorf("dry run deleted blobs")
+	}
+	if env.c.Preview() != rep || env.c.Last() != nil {
+		t.Errorf("dry run is not the preview")
+	}
+	rep, err = env.c.Run(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(env.keptRevs(t)); got != "[5 6 7]" {
+		t.Errorf("kept artifacts of revisions %v", got)
+	}
+	if len(rep.Files) != 10 || rep.Blobs != 5 {
+		t.Errorf("got %v files and %v blobs", len(rep.Files), rep.Blobs)
+	}
+	// The shared blob is still referenced by the kept revisions.
+	if !env.blobs.Has(env.shared) {
+		t.Errorf("referenced blob was deleted")
+	}
+	for i, rev := range env.revs {
+		if got, want := env.blobs.Has(blob.Hash([]byte("profile of "+rev.Id))), i >= 5; got != want {
+			t.Errorf("blob of revision #%v: exists %v, want %v", i, got, want)
+		}
+	}
+	if env.c.Last() != rep || env.c.Preview() != nil {
+		t.Errorf("run is not the last one or the preview is stale")
+	}
+	// Nothing is left to collect.
+	if rep, err := env.c.Run(false); err != nil || len(rep.Files) != 0 || rep.Blobs != 0 {
+		t.Errorf("second run: got %+v, %v", rep, err)
+	}
+}
+
+func TestKeepAll(t *testing.T) {
+	env := newTestEnv(t, 4, config.Retention{})
+	defer env.close()
+	rep, err := env.c.Run(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Files) != 0 || rep.Blobs != 0 || rep.Kept != 8 {
+		t.Errorf("got %v files, %v kept, %v blobs", len(rep.Files), rep.Kept, rep.Blobs)
+	}
+}
+
+func TestKeepTagsAndChanges(t *testing.T) {
+	env := newTestEnv(t, 8, config.Retention{Revs: 2, Tags: "go1*", Changes: true})
+	defer env.close()
+	changes := []*db.Change{
+		{Id: "r", Project: "test", Benchmark: "json", Machine: "m1", Metric: "time", Procs: 1,
+			Prev: env.revs[3].Id, Rev: env.revs[4].Id, Regression: true},
+		// Improvements do not keep artifacts.
+		{Id: "i", Project: "test", Benchmark: "json", Machine: "m1", Metric: "rss", Procs: 1,
+			Prev: env.revs[0].Id, Rev: env.revs[1].Id},
+	}
+	for _, c := range changes {
+		if err := env.store.AddChange(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := env.c.Run(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(env.keptRevs(t)); got != "[2 3 4 6 7]" {
+		t.Errorf("kept artifacts of revisions %v", got)
+	}
+}
+
+func TestGrace(t *testing.T) {
+	env := newTestEnv(t, 4, config.Retention{Revs: 1})
+	defer env.close()
+	// Blobs of expired revisions were stored recently.
+	env.age(Grace / 2)
+	orphan, err := env.blobs.Put([]byte("not referenced yet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep, err := env.c.Run(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Files) != 6 || rep.Blobs != 0 || !env.blobs.Has(orphan) {
+		t.Errorf("got %v files and %v blobs, want 6 and 0", len(rep.Files), rep.Blobs)
+	}
+	// Once they are older than Grace, they are collected.
+	env.age(Grace + time.Minute)
+	rep, err = env.c.Run(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Files) != 0 || rep.Blobs != 4 || env.blobs.Has(orphan) || !env.blobs.Has(env.shared) {
+		t.Errorf("got %v files and %v blobs, want 0 and 4", len(rep.Files), rep.Blobs)
+	}
+}
+
+func TestUploadBeforeReference(t *testing.T) {
+	env := newTestEnv(t, 2, config.Retention{Revs: 1})
+	defer env.close()
+	// The server stores the upload, then adds its record. An old blob with the same
+	// contents becomes new again.
+	data := []byte("profile of " + env.revs[0].Id)
+	hash, err := env.blobs.Put(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep, err := env.c.Run(false); err != nil || rep.Blobs != 0 || !env.blobs.Has(hash) {
+		t.Fatalf("uploaded blob is collected: %+v, %v", rep, err)
+	}
+	f := &db.File{Project: "test", Rev: env.revs[1].Id, Benchmark: "http", Machine: "m1", Procs: 1,
+		Name: "cpuprof", Hash: hash, Size: int64(len(data)), Time: time.Now()}
+	if err := env.store.AddFile(f); err != nil {
+		t.Fatal(err)
+	}
+	env.age(2 * Grace)
+	if rep, err := env.c.Run(false); err != nil || rep.Blobs != 0 || !env.blobs.Has(hash) {
+		t.Errorf("referenced blob is collected: %+v, %v", rep, err)
+	}
+}
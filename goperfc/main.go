@@ -1,4 +1,316 @@
+// goperfc is the builder agent that runs on perf machines.
+// It polls goperfd for jobs, builds the Go toolchain at the requested revision,
+// builds the bench binary with it, runs the benchmark and uploads the results.
 package main
 
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/goperfd/builder"
+)
+
+var (
+	server  = flag.String("server", "http://localhost:33333/Go", "project URL on goperfd")
+	machine = flag.String("machine", "", "machine name (as in project config)")
+	key     = flag.String("key", "", "machine key (as in project config)")
+	goroot  = flag.String("goroot", "", "git clone of the Go repository, used to build toolchains")
+	gopath  = flag.String("gopath", "", "GOPATH that contains code.google.com/p/goperfd")
+	workDir = flag.String("workdir", filepath.Join(os.TempDir(), "goperfc"), "dir for temporary files")
+	poll    = flag.Duration("poll", time.Minute, "poll period when there is nothing to do")
+	beat    = flag.Duration("heartbeat", time.Minute, "heartbeat period while running a job")
+)
+
+const (
+	benchPkg    = "code.google.com/p/goperfd/bench"
+	maxFileSize = 16 << 20
+	maxLogSize  = 8 << 10
+)
+
 func main() {
+	flag.Parse()
+	if *machine == "" || *key == "" || *goroot == "" || *gopath == "" {
+		fmt.Fprintf(os.Stderr, "specify machine, key, goroot and gopath\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	a := &agent{
+		client:  builder.NewClient(*server, *machine, *key),
+		tc:      &goToolchain{goroot: *goroot, gopath: *gopath, workDir: *workDir},
+		workDir: *workDir,
+		beat:    *beat,
+	}
+	for {
+		if !a.runOnce() {
+			time.Sleep(*poll)
+		}
+	}
+}
+
+// agent polls goperfd for jobs, runs them and uploads the results.
+type agent struct {
+	client  *builder.Client
+	tc      toolchain
+	workDir string
+	beat    time.Duration // heartbeat period
+
+	mu     sync.Mutex
+	status builder.Heartbeat // what the agent is doing at the moment
+}
+
+// toolchain builds and runs the bench binary.
+type toolchain interface {
+	// Build prepares the bench binary for the revision and returns its path.
+	Build(rev string) (string, error)
+	// Run runs the bench binary with GOMAXPROCS=procs and returns its output.
+	Run(bin string, procs int, args ...string) ([]byte, error)
+}
+
+// goToolchain builds the Go toolchain from the git clone in goroot
+// and the bench binary from gopath with it.
+type goToolchain struct {
+	goroot  string
+	gopath  string
+	workDir string
+	// lastRev is the revision the toolchain in goroot is built for.
+	lastRev string
+}
+
+// runOnce executes a single job. It returns false if there was nothing to do
+// or goperfd is unreachable.
+func (a *agent) runOnce() bool {
+	job, err := a.client.Work()
+	if err != nil {
+		log.Printf("failed to get work: %v", err)
+		return false
+	}
+	if job == nil {
+		return false
+	}
+	log.Printf("running job %v: %v@%v", job.Id, job.Benchmark, job.Rev)
+	stop := make(chan bool)
+	go a.heartbeat(stop)
+	rep := a.do(job)
+	close(stop)
+	if rep.Error != "" {
+		log.Printf("job %v failed: %v", job.Id, rep.Error)
+	}
+	if err := a.client.Report(rep); err != nil {
+		log.Printf("failed to report results of job %v: %v", job.Id, err)
+	}
+	return true
+}
+
+// heartbeat periodically sends the agent status to goperfd until stop is closed.
+func (a *agent) heartbeat(stop chan bool) {
+	ticker := time.NewTicker(a.beat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		a.mu.Lock()
+		hb := a.status
+		a.mu.Unlock()
+		if err := a.client.Heartbeat(&hb); err != nil {
+			log.Printf("failed to send heartbeat: %v", err)
+		}
+	}
+}
+
+func (a *agent) setStatus(job, status string, progress float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.status = builder.Heartbeat{Job: job, Status: status, Progress: progress}
+}
+
+func (a *agent) do(job *builder.Job) *builder.Report {
+	rep := &builder.Report{Job: job.Id, Rev: job.Rev, Benchmark: job.Benchmark}
+	// Building is accounted as one step, and every run as one more.
+	steps := float64(len(job.Procs) + 1)
+	a.setStatus(job.Id, "building", 0)
+	bin, err := a.tc.Build(job.Rev)
+	if err != nil {
+		rep.Error = err.Error()
+		return rep
+	}
+	for i, procs := range job.Procs {
+		a.setStatus(job.Id, fmt.Sprintf("running with GOMAXPROCS=%v", procs), float64(i+1)/steps)
+		run, err := a.runBench(bin, job, procs)
+		if err != nil {
+			rep.Error = err.Error()
+			rep.Runs = nil
+			return rep
+		}
+		rep.Runs = append(rep.Runs, *run)
+	}
+	return rep
+}
+
+// Build builds the toolchain at rev and the bench binary with it.
+func (t *goToolchain) Build(rev string) (string, error) {
+	if rev != t.lastRev {
+		t.lastRev = ""
+		if _, err := command(t.goroot, nil, "git", "fetch", "--quiet"); err != nil {
+			log.Printf("%v", err) // the revision can be available anyway
+		}
+		if _, err := command(t.goroot, nil, "git", "checkout", "--quiet", "--force", rev); err != nil {
+			return "", err
+		}
+		if _, err := command(t.goroot, nil, "git", "clean", "-dfxq"); err != nil {
+			return "", err
+		}
+		makeScript := "./make.bash"
+		if runtime.GOOS == "windows" {
+			makeScript = "make.bat"
+		}
+		if _, err := command(filepath.Join(t.goroot, "src"), t.env(0), makeScript); err != nil {
+			return "", err
+		}
+		t.lastRev = rev
+	}
+	bin := filepath.Join(t.workDir, "bench")
+	if runtime.GOOS == "windows" {
+		bin += ".exe"
+	}
+	if _, err := command(t.workDir, t.env(0), filepath.Join(t.goroot, "bin", "go"), "build", "-o", bin, benchPkg); err != nil {
+		return "", err
+	}
+	return bin, nil
+}
+
+// Run runs the bench binary in the bench package dir, benchmarks read their data from there.
+func (t *goToolchain) Run(bin string, procs int, args ...string) ([]byte, error) {
+	dir := filepath.Join(t.gopath, "src", filepath.FromSlash(benchPkg))
+	return command(dir, t.env(procs), bin, args...)
+}
+
+var (
+	metricRe = regexp.MustCompile("^GOPERF-METRIC:([a-zA-Z0-9_.-]+)=([0-9]+)$")
+	fileRe   = regexp.MustCompile("^GOPERF-FILE:([a-zA-Z0-9_-]+)=(.+)$")
+)
+
+// runBench runs the benchmark and collects metrics and files it reports.
+func (a *agent) runBench(bin string, job *builder.Job, procs int) (*builder.Run, error) {
+	tmpDir := filepath.Join(a.workDir, "tmp")
+	os.RemoveAll(tmpDir)
+	if err := os.MkdirAll(tmpDir, 0750); err != nil {
+		return nil, err
+	}
+	args := append([]string{"-bench", job.Benchmark, "-tmpdir", tmpDir}, job.Flags...)
+	out, err := a.tc.Run(bin, procs, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseRun(job.Benchmark, out, procs)
+}
+
+// parseRun parses output of the bench binary for a single benchmark.
+func parseRun(bench string, out []byte, procs int) (*builder.Run, error) {
+	run := &builder.Run{
+		Procs:   procs,
+		Metrics: make(map[string]uint64),
+		Files:   make(map[string][]byte),
+	}
+	sections := 0
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		ln := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(ln, "GOPERF-BENCH:") {
+			// Metrics of several benchmarks would be mixed up.
+			if sections++; sections > 1 {
+				return nil, fmt.Errorf("'%v' selects several benchmarks", bench)
+			}
+		} else if ss := metricRe.FindStringSubmatch(ln); ss != nil {
+			v, err := strconv.ParseUint(ss[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse metric '%v': %v", ln, err)
+			}
+			run.Metrics[ss[1]] = v
+		} else if ss := fileRe.FindStringSubmatch(ln); ss != nil {
+			data, err := readFile(ss[2])
+			if err != nil {
+				log.Printf("failed to read file '%v': %v", ss[2], err)
+				continue
+			}
+			run.Files[ss[1]] = data
+		}
+	}
+	if len(run.Metrics) == 0 {
+		return nil, fmt.Errorf("%v did not report any metrics:\n%s", bench, tail(out))
+	}
+	return run, nil
+}
+
+func readFile(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() > maxFileSize {
+		return nil, fmt.Errorf("file is too large (%v bytes)", st.Size())
+	}
+	return ioutil.ReadAll(f)
+}
+
+// env returns environment for the toolchain and the bench binary.
+// procs is GOMAXPROCS value, 0 means unset.
+func (t *goToolchain) env(procs int) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		switch {
+		case strings.HasPrefix(kv, "GOROOT="), strings.HasPrefix(kv, "GOPATH="),
+			strings.HasPrefix(kv, "GOMAXPROCS="), strings.HasPrefix(kv, "PATH="):
+			continue
+		}
+		env = append(env, kv)
+	}
+	env = append(env,
+		"GOROOT="+t.goroot,
+		"GOPATH="+t.gopath,
+		"GO111MODULE=off",
+		"PATH="+filepath.Join(t.goroot, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"))
+	if procs != 0 {
+		env = append(env, "GOMAXPROCS="+strconv.Itoa(procs))
+	}
+	return env
+}
+
+// command runs the command and returns its combined output.
+func command(dir string, env []string, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("'%v %v' failed: %v\n%s", name, strings.Join(args, " "), err, tail(out))
+	}
+	return out, nil
+}
+
+func tail(out []byte) []byte {
+	if len(out) > maxLogSize {
+		out = out[len(out)-maxLogSize:]
+	}
+	return out
 }
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/goperfd/blob"
+	"code.google.com/p/goperfd/builder"
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/regress"
+	"code.google.com/p/goperfd/repo"
+	"code.google.com/p/goperfd/sched"
+	backend "code.google.com/p/goperfd/server"
+)
+
+// TestHelperBench is not a real test, it is the fake bench binary
+// that is run by fakeToolchain.
+func TestHelperBench(t *testing.T) {
+	if os.Getenv("GOPERFC_FAKE_BENCH") != "1" {
+		return
+	}
+	var bench, tmpDir string
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	for i := 1; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-bench":
+			bench = args[i+1]
+		case "-tmpdir":
+			tmpDir = args[i+1]
+		}
+	}
+	if bench != "json" {
+		fmt.Printf("unknown benchmark '%v'\n", bench)
+		os.Exit(1)
+	}
+	procs := os.Getenv("GOMAXPROCS")
+	prof := filepath.Join(tmpDir, "cpuprof.txt")
+	if err := ioutil.WriteFile(prof, []byte("profile with GOMAXPROCS="+procs), 0600); err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("some chatter\n")
+	fmt.Printf("GOPERF-METRIC:time=%v00\n", procs)
+	fmt.Printf("GOPERF-METRIC:allocs=3\n")
+	fmt.Printf("GOPERF-FILE:cpuprof=%v\n", prof)
+	os.Exit(0)
+}
+
+// fakeToolchain runs the test binary as the bench binary.
+type fakeToolchain struct {
+	builds []string
+}
+
+func (t *fakeToolchain) Build(rev string) (string, error) {
+	t.builds = append(t.builds, rev)
+	return os.Args[0], nil
+}
+
+func (t *fakeToolchain) Run(bin string, procs int, args ...string) ([]byte, error) {
+	env := append(os.Environ(), "GOPERFC_FAKE_BENCH=1", "GOMAXPROCS="+strconv.Itoa(procs))
+	return command("", env, bin, append([]string{"-test.run=^TestHelperBench$", "--"}, args...)...)
+}
+
+func TestAgent(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "goperfc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gitDir := filepath.Join(dir, "repo")
+	os.Mkdir(gitDir, 0750)
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"commit", "--quiet", "--allow-empty", "-m", "1"},
+		{"commit", "--quiet", "--allow-empty", "-m", "2"},
+		{"commit", "--quiet", "--allow-empty", "-m", "3"},
+	} {
+		env := append(os.Environ(), "GIT_AUTHOR_NAME=Gopher", "GIT_AUTHOR_EMAIL=gopher@example.com",
+			"GIT_COMMITTER_NAME=Gopher", "GIT_COMMITTER_EMAIL=gopher@example.com", "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+		if _, err := command(gitDir, env, "git", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := repo.Open(gitDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := db.Open(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	blobs, err := blob.Open(filepath.Join(dir, "blobs"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project := new(config.ProjectFile)
+	project.Set(&config.ProjectConfig{
+		Name:       "goperfctest",
+		Benchmarks: []config.Benchmark{{Name: "json"}, {Name: "broken"}},
+		Machines:   []config.Machine{{Name: "m1", Key: "key", Procs: []int{1, 2}}},
+	})
+	sc := sched.New(r, store)
+	srv := backend.New(store, blobs, r, sc, regress.NewDetector(project, r, store))
+	if err := builder.RegisterHandlers(project, srv); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.DefaultServeMux)
+	defer ts.Close()
+
+	workDir := filepath.Join(dir, "work")
+	os.Mkdir(workDir, 0750)
+	tc := new(fakeToolchain)
+	a := &agent{
+		client:  builder.NewClient(ts.URL+"/goperfctest", "m1", "key"),
+		tc:      tc,
+		workDir: workDir,
+		beat:    10 * time.Millisecond,
+	}
+	jobs := 0
+	for a.runOnce() {
+		if jobs++; jobs > 20 {
+			t.Fatalf("too many jobs")
+		}
+	}
+	// 3 revisions of json, and 3 revisions of broken that is retried 3 times.
+	if jobs != 12 || len(tc.builds) != 12 {
+		t.Errorf("ran %v jobs and %v builds, want 12", jobs, len(tc.builds))
+	}
+	if leases := sc.Leases(); len(leases) != 0 {
+		t.Errorf("%v leases are left", len(leases))
+	}
+
+	for _, rev := range r.Revs() {
+		results, err := store.Revision("goperfctest", rev.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, res := range results {
+			got = append(got, fmt.Sprintf("%v/%v/%v=%v", res.Benchmark, res.Procs, res.Metric, res.Value))
+		}
+		sort.Strings(got)
+		want := "json/1/allocs=3 json/1/time=100 json/2/allocs=3 json/2/time=200"
+		if strings.Join(got, " ") != want {
+			t.Errorf("results at %v: %v, want %v", rev.Id, got, want)
+		}
+		files, err := store.Files("goperfctest", rev.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 2 {
+			t.Fatalf("%v files at %v, want 2", len(files), rev.Id)
+		}
+		for _, f := range files {
+			data, err := blobs.Get(f.Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("profile with GOMAXPROCS=%v", f.Procs); f.Name != "cpuprof" || string(data) != want {
+				t.Errorf("file %v at %v with GOMAXPROCS=%v: %q, want %q", f.Name, rev.Id, f.Procs, data, want)
+			}
+		}
+	}
+}
+
+func TestParseRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goperfc-parse-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	prof := filepath.Join(dir, "cpu.prof")
+	if err := ioutil.WriteFile(prof, []byte("profile"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	out := "starting\nGOPERF-METRIC:time=100\n  GOPERF-METRIC:rss=2000  \nGOPERF-FILE:cpuprof=" + prof +
+		"\nGOPERF-FILE:memprof=" + filepath.Join(dir, "nosuch") + "\n"
+	run, err := parseRun("json", []byte(out), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Procs != 4 || len(run.Metrics) != 2 || run.Metrics["time"] != 100 || run.Metrics["rss"] != 2000 {
+		t.Errorf("bad run: %+v", run)
+	}
+	// Unreadable files are skipped.
+	if len(run.Files) != 1 || string(run.Files["cpuprof"]) != "profile" {
+		t.Errorf("bad files: %q", run.Files)
+	}
+	// A single section is the output of a pattern that matches one benchmark.
+	if run, err := parseRun("j*", []byte("GOPERF-BENCH:json\nGOPERF-METRIC:time=100\n"), 1); err != nil || run.Metrics["time"] != 100 {
+		t.Errorf("single section: got %+v, %v", run, err)
+	}
+	for _, bad := range []string{
+		"GOPERF-BENCH:json\nGOPERF-METRIC:time=100\nGOPERF-BENCH:http\nGOPERF-METRIC:time=200\n",
+		"GOPERF-METRIC:time=99999999999999999999\n",
+		"panic: boom\n",
+		"",
+	} {
+		if run, err := parseRun("json", []byte(bad), 1); err == nil {
+			t.Errorf("%q: got %+v, want an error", bad, run)
+		}
+	}
+}
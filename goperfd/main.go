@@ -2,31 +2,99 @@ package main
 
 import (
 	"log"
-	"os"
 	"net/http"
+	"os"
+	"path/filepath"
+	"time"
 
-	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/api"
+	"code.google.com/p/goperfd/blob"
 	"code.google.com/p/goperfd/builder"
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/gc"
+	"code.google.com/p/goperfd/notify"
+	"code.google.com/p/goperfd/regress"
+	"code.google.com/p/goperfd/repo"
+	"code.google.com/p/goperfd/sched"
+	"code.google.com/p/goperfd/server"
 	"code.google.com/p/goperfd/ui"
 )
 
 func main() {
-	if len(os.Args) != 3 {
-		log.Fatalf("usage: %v project.cfg host.cfg", os.Args[0])
+	if len(os.Args) < 3 {
+		log.Fatalf("usage: %v project.cfg [project.cfg...] host.cfg", os.Args[0])
+	}
+	hostFile := os.Args[len(os.Args)-1]
+	var projects []*config.ProjectFile
+	names := make(map[string]string)
+	for _, filename := range os.Args[1 : len(os.Args)-1] {
+		project, err := config.LoadProject(filename)
+		if err != nil {
+			log.Fatalf("failed to load project config file '%v':\n%v", filename, err)
+		}
+		name := project.Get().Name
+		if prev := names[name]; prev != "" {
+			log.Fatalf("project '%v' is defined in both '%v' and '%v'", name, prev, filename)
+		}
+		names[name] = filename
+		projects = append(projects, project)
 	}
-	if err := config.Load(&config.Project, os.Args[1]); err != nil {
-		log.Fatalf("failed to load project config file '%v' (%v)", os.Args[1], err)
+	if err := config.Load(&config.Host, hostFile); err != nil {
+		log.Fatalf("failed to load host config file '%v' (%v)", hostFile, err)
 	}
-	if err := config.Load(&config.Host, os.Args[2]); err != nil {
-		log.Fatalf("failed to load host config file '%v' (%v)", os.Args[2], err)
+	if err := config.Host.Validate(); err != nil {
+		log.Fatalf("bad host config file '%v':\n%v", hostFile, err)
 	}
-	if err := ui.RegisterHandlers(); err != nil {
+	store, err := db.Open(config.Host.Dir)
+	if err != nil {
+		log.Fatalf("failed to open database in '%v' (%v)", config.Host.Dir, err)
+	}
+	blobs, err := blob.Open(filepath.Join(config.Host.Dir, "blobs"), config.Host.Compress)
+	if err != nil {
+		log.Fatalf("failed to open blob store (%v)", err)
+	}
+	var gcProjects []*gc.Project
+	for _, project := range projects {
+		rp := serveProject(project, store, blobs)
+		gcProjects = append(gcProjects, &gc.Project{Config: project, Repo: rp})
+	}
+	collector := gc.New(store, blobs, gcProjects)
+	go collector.Poll(6 * time.Hour)
+	if err := ui.RegisterIndex(projects); err != nil {
 		log.Fatalf("failed to register ui handlers (%v)", err)
 	}
-	if err := builder.RegisterHandlers(); err != nil {
-		log.Fatalf("failed to register builder handlers (%v)", err)
+	if err := ui.RegisterGC(collector); err != nil {
+		log.Fatalf("failed to register ui handlers (%v)", err)
 	}
 	if err := http.ListenAndServe(config.Host.Addr, nil); err != nil {
 		log.Fatalf("failed to listen and serve on '%v' (%v)", config.Host.Addr, err)
 	}
 }
+
+// serveProject starts background work for the project and registers its handlers.
+// The database and the blob store are shared by all projects.
+func serveProject(project *config.ProjectFile, store db.Store, blobs *blob.Store) *repo.Repo {
+	cfg := project.Get()
+	go project.Watch(10 * time.Second)
+	rp, err := repo.Open(cfg.Repo, cfg.Branch)
+	if err != nil {
+		log.Fatalf("failed to open repository '%v' of project '%v' (%v)", cfg.Repo, cfg.Name, err)
+	}
+	go rp.Poll(time.Minute)
+	detector := regress.NewDetector(project, rp, store)
+	detector.Notify = notify.New(rp).Notify
+	go detector.Poll(10 * time.Minute)
+	sc := sched.New(rp, store)
+	if err := ui.RegisterHandlers(project, store, blobs, rp, sc); err != nil {
+		log.Fatalf("failed to register ui handlers (%v)", err)
+	}
+	if err := api.RegisterHandlers(project, store, rp); err != nil {
+		log.Fatalf("failed to register api handlers (%v)", err)
+	}
+	if err := builder.RegisterHandlers(project, server.New(store, blobs, rp, sc, detector)); err != nil {
+		log.Fatalf("failed to register builder handlers (%v)", err)
+	}
+	log.Printf("serving project '%v' at %v/", cfg.Name, cfg.URLPath())
+	return rp
+}
@@ -0,0 +1,166 @@
+// Package notify tells subscribers about detected performance changes
+// via email and outgoing webhooks.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/repo"
+)
+
+// Payload is the JSON document POSTed to webhooks.
+type Payload struct {
+	*db.Change
+	Delta  float64 // relative change of the median
+	Unit   string  // unit of Old and New
+	Author string
+	Desc   string
+	URL    string // revision page
+}
+
+type Notifier struct {
+	repo *repo.Repo
+	HTTP *http.Client
+}
+
+func New(r *repo.Repo) *Notifier {
+	return &Notifier{
+		repo: r,
+		HTTP: &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Notify sends notifications about the change to all matching subscriptions.
+func (n *Notifier) Notify(cfg *config.ProjectConfig, c *db.Change) {
+	p := n.payload(cfg, c)
+	for _, s := range cfg.Subscriptions {
+		if !match(&s, c) {
+			continue
+		}
+		if s.Email != "" {
+			if err := sendMail(&config.Host.SMTP, s.Email, subject(cfg, p), body(cfg.Metric(c.Metric), p)); err != nil {
+				log.Printf("notify: failed to send mail to %v: %v", s.Email, err)
+			}
+		}
+		if s.Webhook != "" {
+			if err := n.post(s.Webhook, p); err != nil {
+				log.Printf("notify: failed to post to %v: %v", s.Webhook, err)
+			}
+		}
+	}
+}
+
+func match(s *config.Subscription, c *db.Change) bool {
+	if !c.Regression && !s.Improvements {
+		return false
+	}
+	return contains(s.Benchmarks, c.Benchmark) && contains(s.Metrics, c.Metric)
+}
+
+// contains returns true if list is empty or contains v.
+func contains(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return len(list) == 0
+}
+
+func (n *Notifier) payload(cfg *config.ProjectConfig, c *db.Change) *Payload {
+	p := &Payload{Change: c, Unit: cfg.Metric(c.Metric).Unit}
+	if p.Unit == "" {
+		p.Unit = config.UnitCount
+	}
+	if c.Old != 0 {
+		p.Delta = (c.New - c.Old) / c.Old
+	}
+	if rev := n.repo.Rev(c.Rev); rev != nil {
+		p.Author = rev.Author
+		p.Desc = rev.Desc
+	}
+	if config.Host.URL != "" {
+		p.URL = strings.TrimSuffix(config.Host.URL, "/") + cfg.URLPath() + "/rev?id=" + url.QueryEscape(c.Rev)
+	}
+	return p
+}
+
+func subject(cfg *config.ProjectConfig, p *Payload) string {
+	what := "improved"
+	if p.Regression {
+		what = "regressed"
+	}
+	return fmt.Sprintf("[goperf] %v: %v %v %v by %+.2f%% at %.10v", cfg.Name, p.Benchmark, p.Metric, what, p.Delta*100, p.Rev)
+}
+
+func body(m *config.Metric, p *Payload) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Benchmark:  %v\n", p.Benchmark)
+	fmt.Fprintf(&buf, "Metric:     %v\n", p.Metric)
+	fmt.Fprintf(&buf, "Machine:    %v (GOMAXPROCS=%v)\n", p.Machine, p.Procs)
+	fmt.Fprintf(&buf, "Change:     %v -> %v (%+.2f%%, p=%.4f)\n", m.Format(p.Old), m.Format(p.New), p.Delta*100, p.P)
+	fmt.Fprintf(&buf, "Revisions:  %v..%v\n", p.Prev, p.Rev)
+	if p.URL != "" {
+		fmt.Fprintf(&buf, "Details:    %v\n", p.URL)
+	}
+	if p.Author != "" {
+		fmt.Fprintf(&buf, "\nAuthor: %v\n\n%v\n", p.Author, p.Desc)
+	}
+	return buf.String()
+}
+
+func sendMail(cfg *config.SMTPConfig, to, subject, body string) error {
+	if cfg.Addr == "" {
+		return fmt.Errorf("SMTP server is not configured")
+	}
+	var auth smtp.Auth
+	if cfg.User != "" {
+		host, _, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", cfg.User, cfg.Password, host)
+	}
+	msg := fmt.Sprintf("From: %v\r\nTo: %v\r\nSubject: %v\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%v",
+		header(cfg.From), header(to), header(subject), strings.Replace(body, "\n", "\r\n", -1))
+	return smtp.SendMail(cfg.Addr, auth, cfg.From, []string{to}, []byte(msg))
+}
+
+// header strips line breaks from a header value, so that it cannot add headers.
+func header(v string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, v)
+}
+
+func (n *Notifier) post(addr string, p *Payload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	resp, err := n.HTTP.Post(addr, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%v: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
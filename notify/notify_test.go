@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/repo"
+)
+
+// mail is a message received by the fake SMTP server.
+type mail struct {
+	from, to string
+	data     string
+}
+
+// serveSMTP implements just enough of SMTP for net/smtp.SendMail.
+func serveSMTP(ln net.Listener, mails chan<- *mail) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+			reply("220 localhost fake SMTP")
+			m := new(mail)
+			for {
+				ln, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				cmd := strings.TrimRight(ln, "\r\n")
+				switch {
+				case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+					reply("250 localhost")
+				case strings.HasPrefix(cmd, "MAIL FROM:"):
+					m.from = strings.Trim(cmd[len("MAIL FROM:"):], "<> ")
+					reply("250 OK")
+				case strings.HasPrefix(cmd, "RCPT TO:"):
+					m.to = strings.Trim(cmd[len("RCPT TO:"):], "<> ")
+					reply("250 OK")
+				case cmd == "DATA":
+					reply("354 go ahead")
+					var data []string
+					for {
+						ln, err := r.ReadString('\n')
+						if err != nil {
+							return
+						}
+						if ln == ".\r\n" {
+							break
+						}
+						data = append(data, ln)
+					}
+					m.data = strings.Join(data, "")
+					mails <- m
+					m = new(mail)
+					reply("250 OK")
+				case cmd == "QUIT":
+					reply("221 bye")
+					return
+				default:
+					reply("502 not implemented")
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	mails := make(chan *mail, 10)
+	go serveSMTP(ln, mails)
+
+	posts := make(chan *Payload, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		p := new(Payload)
+		if err := json.Unmarshal(data, p); err != nil {
+			t.Errorf("bad webhook payload: %v\n%s", err, data)
+		}
+		posts <- p
+	}))
+	defer hook.Close()
+
+	defer func(h config.HostConfig) { config.Host = h }(config.Host)
+	config.Host.URL = "http://perf.example.com/"
+	config.Host.SMTP = config.SMTPConfig{Addr: ln.Addr().String(), From: "goperf@example.com"}
+	cfg := &config.ProjectConfig{
+		Name:    "Go",
+		Metrics: []config.Metric{{Name: "time", Unit: config.UnitNs}},
+		Subscriptions: []config.Subscription{
+			{Email: "dev@example.com", Webhook: hook.URL, Benchmarks: []string{"json"}},
+			{Email: "other@example.com", Benchmarks: []string{"http"}},
+			{Webhook: hook.URL + "/improvements", Improvements: true},
+		},
+	}
+	n := New(new(repo.Repo))
+	c := &db.Change{Id: "1", Project: "Go", Benchmark: "json", Machine: "m", Metric: "time",
+		Procs: 1, Rev: "0123456789abcdef", Prev: "fedcba9876543210", Old: 100, New: 150, Regression: true}
+	n.Notify(cfg, c)
+
+	m := <-mails
+	if m.from != "goperf@example.com" || m.to != "dev@example.com" {
+		t.Errorf("mail from %v to %v", m.from, m.to)
+	}
+	for _, s := range []string{
+		"Subject: [goperf] Go: json time regressed by +50.00% at 0123456789\r\n",
+		"To: dev@example.com\r\n",
+		"Details:    http://perf.example.com/Go/rev?id=0123456789abcdef\r\n",
+	} {
+		if !strings.Contains(m.data, s) {
+			t.Errorf("mail does not contain %q:\n%v", s, m.data)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		p := <-posts
+		if p.Benchmark != "json" || p.Delta != 0.5 || p.Unit != config.UnitNs || p.URL == "" {
+			t.Errorf("bad payload %+v", p)
+		}
+	}
+	select {
+	case m := <-mails:
+		t.Errorf("unexpected mail to %v", m.to)
+	case p := <-posts:
+		t.Errorf("unexpected post %+v", p)
+	default:
+	}
+
+	// Names that reach the headers must not be able to add headers.
+	c.Metric = "time\r\nBcc: victim@example.com"
+	n.Notify(cfg, c)
+	m = <-mails
+	headers := m.data[:strings.Index(m.data, "\r\n\r\n")]
+	if strings.Contains(headers, "\nBcc:") || !strings.Contains(headers, "time  Bcc:") {
+		t.Errorf("header injection:\n%v", m.data)
+	}
+	<-posts
+	<-posts
+}
@@ -0,0 +1,301 @@
+// Package profile parses CPU and memory profiles uploaded by builders
+// and compares them function by function.
+//
+// Two formats are understood: gzipped profile.proto as written by runtime/pprof
+// since Go 1.9, and the text rendering of 'go tool pprof --text'. Profiles in the
+// legacy binary format are not symbolized, so they can only be compared
+// through their text rendering.
+package profile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"code.google.com/p/goperfd/config"
+)
+
+// MinFraction is the min share of the profile total that a function
+// must take in either profile to be compared.
+const MinFraction = 0.001
+
+// Profile is the cost of functions.
+type Profile struct {
+	Unit  string // config.UnitNs, config.UnitBytes or config.UnitCount
+	Total int64
+	Funcs map[string]*Func
+}
+
+// Func is the cost attributed to a function.
+type Func struct {
+	Name string
+	Flat int64 // spent in the function itself
+	Cum  int64 // spent in the function and its callees
+}
+
+// Parse parses a profile in one of the supported formats.
+func Parse(data []byte) (*Profile, error) {
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		raw, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress profile: %v", err)
+		}
+		return parseProto(raw)
+	}
+	return parseText(data)
+}
+
+func parseProto(data []byte) (*Profile, error) {
+	pp, err := decodeProto(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode profile: %v", err)
+	}
+	if len(pp.sampleTypes) == 0 {
+		return nil, fmt.Errorf("profile has no sample types")
+	}
+	// Use allocated bytes for heap profiles (the same as pprof --alloc_space
+	// in the driver) and the last sample type (cpu time) for everything else.
+	idx := len(pp.sampleTypes) - 1
+	for i, st := range pp.sampleTypes {
+		if pp.str(st.typ) == "alloc_space" {
+			idx = i
+		}
+	}
+	p := &Profile{Unit: config.UnitCount, Funcs: make(map[string]*Func)}
+	switch pp.str(pp.sampleTypes[idx].unit) {
+	case "nanoseconds":
+		p.Unit = config.UnitNs
+	case "bytes":
+		p.Unit = config.UnitBytes
+	}
+	for _, s := range pp.samples {
+		if idx >= len(s.values) {
+			continue
+		}
+		v := s.values[idx]
+		p.Total += v
+		seen := make(map[string]bool)
+		for i, loc := range s.locations {
+			for j, fn := range pp.locations[loc] {
+				name := pp.str(pp.functions[fn])
+				if name == "" {
+					continue
+				}
+				f := p.fn(name)
+				if i == 0 && j == 0 {
+					f.Flat += v
+				}
+				if !seen[name] {
+					seen[name] = true
+					f.Cum += v
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+// textRe matches a function line of pprof --text output:
+// flat flat% sum% cum cum% name.
+var textRe = regexp.MustCompile(`^\s*([0-9.]+)([a-zµ]*|[kKMGT]?B)\s+[0-9.]+%\s+[0-9.]+%\s+([0-9.]+)([a-zµ]*|[kKMGT]?B)\s+[0-9.]+%\s+(.+)$`)
+
+// totalRe matches the total of pprof --text output,
+// e.g. "Total: 123 samples" or "Showing nodes accounting for 1.10s, 91.67% of 1.20s total".
+var totalRe = regexp.MustCompile(`(?:^Total: ([0-9.]+) ?([a-zA-Zµ]*)|of ([0-9.]+)([a-zA-Zµ]*) total)`)
+
+func parseText(data []byte) (*Profile, error) {
+	p := &Profile{Unit: config.UnitCount, Funcs: make(map[string]*Func)}
+	var sum int64
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if m := totalRe.FindStringSubmatch(line); m != nil {
+			num, suffix := m[1], m[2]
+			if num == "" {
+				num, suffix = m[3], m[4]
+			}
+			v, unit, err := parseValue(num, suffix)
+			if err != nil {
+				return nil, err
+			}
+			p.Total, p.Unit = v, unit
+			continue
+		}
+		m := textRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		flat, unit, err := parseValue(m[1], m[2])
+		if err != nil {
+			return nil, err
+		}
+		cum, _, err := parseValue(m[3], m[4])
+		if err != nil {
+			return nil, err
+		}
+		if unit != config.UnitCount {
+			p.Unit = unit
+		}
+		f := p.fn(strings.TrimSpace(m[5]))
+		f.Flat += flat
+		f.Cum += cum
+		sum += flat
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(p.Funcs) == 0 {
+		return nil, fmt.Errorf("not a profile")
+	}
+	if p.Total < sum {
+		p.Total = sum
+	}
+	return p, nil
+}
+
+var suffixes = map[string]struct {
+	unit  string
+	scale float64
+}{
+	"":        {config.UnitCount, 1},
+	"samples": {config.UnitCount, 1},
+	"ns":      {config.UnitNs, 1},
+	"us":      {config.UnitNs, 1e3},
+	"µs":      {config.UnitNs, 1e3},
+	"ms":      {config.UnitNs, 1e6},
+	"s":       {config.UnitNs, 1e9},
+	"min":     {config.UnitNs, 60e9},
+	"hrs":     {config.UnitNs, 3600e9},
+	"B":       {config.UnitBytes, 1},
+	"kB":      {config.UnitBytes, 1 << 10},
+	"KB":      {config.UnitBytes, 1 << 10},
+	"MB":      {config.UnitBytes, 1 << 20},
+	"GB":      {config.UnitBytes, 1 << 30},
+	"TB":      {config.UnitBytes, 1 << 40},
+}
+
+// parseValue parses a value of pprof --text output, e.g. 1.20s or 512kB.
+func parseValue(num, suffix string) (int64, string, error) {
+	s, ok := suffixes[suffix]
+	if !ok {
+		return 0, "", fmt.Errorf("unknown unit '%v'", suffix)
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("bad value '%v'", num)
+	}
+	return int64(v * s.scale), s.unit, nil
+}
+
+func (p *Profile) fn(name string) *Func {
+	f := p.Funcs[name]
+	if f == nil {
+		f = &Func{Name: name}
+		p.Funcs[name] = f
+	}
+	return f
+}
+
+// Sub subtracts base from the profile. It is used for cumulative profiles,
+// e.g. a heap profile taken after the benchmark minus one taken before it.
+func (p *Profile) Sub(base *Profile) {
+	p.Total -= base.Total
+	for name, bf := range base.Funcs {
+		f := p.fn(name)
+		f.Flat -= bf.Flat
+		f.Cum -= bf.Cum
+	}
+	for name, f := range p.Funcs {
+		if f.Flat <= 0 && f.Cum <= 0 {
+			delete(p.Funcs, name)
+		}
+	}
+}
+
+// Delta compares a function in two profiles.
+// Costs are fractions of the profile totals, because profiles of different runs
+// cover different numbers of iterations.
+type Delta struct {
+	Name   string
+	Old    float64 // flat
+	New    float64
+	OldCum float64
+	NewCum float64
+	Abs    float64 // New - Old
+	Rel    float64 // Abs / Old, +Inf if the function is not in the old profile
+}
+
+// Diff compares profiles a and b.
+// Functions below MinFraction in both profiles are omitted.
+func Diff(a, b *Profile) []*Delta {
+	names := make(map[string]bool)
+	for name := range a.Funcs {
+		names[name] = true
+	}
+	for name := range b.Funcs {
+		names[name] = true
+	}
+	var res []*Delta
+	for name := range names {
+		d := &Delta{Name: name}
+		if f := a.Funcs[name]; f != nil {
+			d.Old, d.OldCum = fraction(f.Flat, a.Total), fraction(f.Cum, a.Total)
+		}
+		if f := b.Funcs[name]; f != nil {
+			d.New, d.NewCum = fraction(f.Flat, b.Total), fraction(f.Cum, b.Total)
+		}
+		if math.Max(math.Max(d.Old, d.New), math.Max(d.OldCum, d.NewCum)) < MinFraction {
+			continue
+		}
+		d.Abs = d.New - d.Old
+		switch {
+		case d.Old != 0:
+			d.Rel = d.Abs / d.Old
+		case d.New != 0:
+			d.Rel = math.Inf(1)
+		}
+		res = append(res, d)
+	}
+	return res
+}
+
+func fraction(v, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(v) / float64(total)
+}
+
+// ByAbs sorts deltas by absolute change, largest first.
+type ByAbs []*Delta
+
+func (p ByAbs) Len() int      { return len(p) }
+func (p ByAbs) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p ByAbs) Less(i, j int) bool {
+	if a, b := math.Abs(p[i].Abs), math.Abs(p[j].Abs); a != b {
+		return a > b
+	}
+	return p[i].Name < p[j].Name
+}
+
+// ByRel sorts deltas by relative change, largest first.
+type ByRel []*Delta
+
+func (p ByRel) Len() int      { return len(p) }
+func (p ByRel) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p ByRel) Less(i, j int) bool {
+	if a, b := math.Abs(p[i].Rel), math.Abs(p[j].Rel); a != b {
+		return a > b
+	}
+	return ByAbs(p).Less(i, j)
+}
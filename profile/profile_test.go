@@ -0,0 +1,287 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"math"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/goperfd/config"
+)
+
+var sink []byte
+
+func spin(d time.Duration) {
+	for t0 := time.Now(); time.Since(t0) < d; {
+		for i := 0; i < 1000; i++ {
+			sink = append(sink[:0], byte(i))
+		}
+	}
+}
+
+func alloc() [][]byte {
+	var res [][]byte
+	for i := 0; i < 1000; i++ {
+		res = append(res, make([]byte, 1024))
+	}
+	return res
+}
+
+// find returns the function with the given package-local name, e.g. "profile.spin".
+func find(p *Profile, name string) *Func {
+	for fn, f := range p.Funcs {
+		if fn == name || strings.HasSuffix(fn, "/"+name) {
+			return f
+		}
+	}
+	return nil
+}
+
+func TestParseCPU(t *testing.T) {
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		t.Skipf("can't start CPU profile: %v", err)
+	}
+	spin(500 * time.Millisecond)
+	pprof.StopCPUProfile()
+	p, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Unit != config.UnitNs || p.Total <= 0 {
+		t.Fatalf("got unit %v total %v", p.Unit, p.Total)
+	}
+	f := find(p, "profile.spin")
+	if f == nil {
+		t.Fatalf("no profile.spin in profile with %v functions", len(p.Funcs))
+	}
+	if f.Cum < f.Flat || f.Cum > p.Total || float64(f.Cum) < 0.5*float64(p.Total) {
+		t.Errorf("spin: flat %v cum %v, total %v", f.Flat, f.Cum, p.Total)
+	}
+	if test := find(p, "profile.TestParseCPU"); test == nil || test.Flat > test.Cum || test.Cum < f.Cum {
+		t.Errorf("caller of spin: %+v, spin cum %v", test, f.Cum)
+	}
+}
+
+func TestParseHeap(t *testing.T) {
+	defer func(rate int) { runtime.MemProfileRate = rate }(runtime.MemProfileRate)
+	runtime.MemProfileRate = 1
+	data := alloc()
+	runtime.GC()
+	var buf bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	runtime.KeepAlive(data)
+	p, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Unit != config.UnitBytes {
+		t.Fatalf("got unit %v", p.Unit)
+	}
+	f := find(p, "profile.alloc")
+	if f == nil {
+		t.Fatalf("no profile.alloc in profile with %v functions", len(p.Funcs))
+	}
+	// alloc_space is used, it includes everything allocated by alloc.
+	if f.Flat < 1000*1024 || f.Cum < f.Flat || p.Total < f.Cum {
+		t.Errorf("alloc: flat %v cum %v, total %v", f.Flat, f.Cum, p.Total)
+	}
+}
+
+func TestParseText(t *testing.T) {
+	p, err := Parse([]byte(`Showing nodes accounting for 1.10s, 91.67% of 1.20s total
+      flat  flat%   sum%        cum   cum%
+     0.60s 50.00% 50.00%      0.80s 66.67%  runtime.mallocgc
+   200ms 16.67% 66.67%      1.10s 91.67%  main.work
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Unit != config.UnitNs || p.Total != 1.2e9 {
+		t.Fatalf("got unit %v total %v", p.Unit, p.Total)
+	}
+	if f := p.Funcs["main.work"]; f == nil || f.Flat != 2e8 || f.Cum != 1.1e9 {
+		t.Errorf("main.work: %+v", f)
+	}
+}
+
+func gzipped(s string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.String()
+}
+
+func TestParseBad(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"not a profile",
+		"\x1f\x8b garbage",
+		gzipped(""),                 // no string table
+		gzipped("\x0a"),             // truncated length of sample type
+		gzipped("\x0a\x05\x08\x01"), // truncated sample type
+		gzipped("\x32\x00"),         // string table does not start with ""
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("parsed %q", data)
+		}
+	}
+}
+
+func prof(total int64, funcs ...Func) *Profile {
+	p := &Profile{Unit: config.UnitNs, Total: total, Funcs: make(map[string]*Func)}
+	for i := range funcs {
+		p.Funcs[funcs[i].Name] = &funcs[i]
+	}
+	return p
+}
+
+func TestDiff(t *testing.T) {
+	inf := math.Inf(1)
+	for _, c := range []struct {
+		desc string
+		a, b *Profile
+		want []Delta
+	}{
+		{
+			"same",
+			prof(100, Func{"f", 50, 100}),
+			prof(200, Func{"f", 100, 200}),
+			[]Delta{{Name: "f", Old: 0.5, New: 0.5, OldCum: 1, NewCum: 1}},
+		},
+		{
+			"only in one side",
+			prof(100, Func{"f", 50, 100}, Func{"old", 50, 50}),
+			prof(100, Func{"f", 60, 100}, Func{"new", 40, 40}),
+			[]Delta{
+				{Name: "f", Old: 0.5, New: 0.6, OldCum: 1, NewCum: 1, Abs: 0.1, Rel: 0.2},
+				{Name: "new", New: 0.4, NewCum: 0.4, Abs: 0.4, Rel: inf},
+				{Name: "old", Old: 0.5, OldCum: 0.5, Abs: -0.5, Rel: -1},
+			},
+		},
+		{
+			"only cum",
+			prof(100, Func{"main", 0, 100}),
+			prof(100, Func{"main", 0, 100}),
+			[]Delta{{Name: "main", OldCum: 1, NewCum: 1}},
+		},
+		{
+			"below min fraction",
+			prof(1e6, Func{"f", 1e6, 1e6}, Func{"tiny", 10, 10}),
+			prof(1e6, Func{"f", 1e6, 1e6}),
+			[]Delta{{Name: "f", Old: 1, New: 1, OldCum: 1, NewCum: 1}},
+		},
+		{
+			"zero totals",
+			prof(0, Func{"f", 0, 0}),
+			prof(0, Func{"f", 10, 10}),
+			nil,
+		},
+		{
+			"zero old total",
+			prof(0),
+			prof(100, Func{"f", 100, 100}),
+			[]Delta{{Name: "f", New: 1, NewCum: 1, Abs: 1, Rel: inf}},
+		},
+		{
+			"empty",
+			prof(0),
+			prof(0),
+			nil,
+		},
+	} {
+		got := Diff(c.a, c.b)
+		if len(got) != len(c.want) {
+			t.Errorf("%v: got %v deltas, want %v", c.desc, len(got), len(c.want))
+			continue
+		}
+		byName := make(map[string]*Delta)
+		for _, d := range got {
+			byName[d.Name] = d
+		}
+		for _, want := range c.want {
+			d := byName[want.Name]
+			if d == nil {
+				t.Errorf("%v: no delta for %v", c.desc, want.Name)
+				continue
+			}
+			if !near(d.Old, want.Old) || !near(d.New, want.New) || !near(d.OldCum, want.OldCum) ||
+				!near(d.NewCum, want.NewCum) || !near(d.Abs, want.Abs) || !near(d.Rel, want.Rel) {
+				t.Errorf("%v: got %+v, want %+v", c.desc, *d, want)
+			}
+		}
+	}
+}
+
+func near(a, b float64) bool {
+	if math.IsInf(a, 0) || math.IsInf(b, 0) {
+		return a == b
+	}
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestSub(t *testing.T) {
+	for _, c := range []struct {
+		desc  string
+		p     *Profile
+		base  *Profile
+		total int64
+		want  map[string]Func
+	}{
+		{
+			"growth",
+			prof(300, Func{"f", 200, 300}, Func{"g", 100, 100}),
+			prof(100, Func{"f", 50, 100}, Func{"g", 50, 50}),
+			200,
+			map[string]Func{"f": {"f", 150, 200}, "g": {"g", 50, 50}},
+		},
+		{
+			"unchanged function is dropped",
+			prof(300, Func{"f", 200, 200}, Func{"g", 100, 100}),
+			prof(100, Func{"g", 100, 100}),
+			200,
+			map[string]Func{"f": {"f", 200, 200}},
+		},
+		{
+			"function only in base is dropped",
+			prof(100, Func{"f", 100, 100}),
+			prof(50, Func{"gone", 50, 50}),
+			50,
+			map[string]Func{"f": {"f", 100, 100}},
+		},
+		{
+			"cum only",
+			prof(100, Func{"main", 0, 100}),
+			prof(40, Func{"main", 0, 40}),
+			60,
+			map[string]Func{"main": {"main", 0, 60}},
+		},
+		{
+			"zero",
+			prof(100, Func{"f", 100, 100}),
+			prof(100, Func{"f", 100, 100}),
+			0,
+			map[string]Func{},
+		},
+	} {
+		c.p.Sub(c.base)
+		if c.p.Total != c.total {
+			t.Errorf("%v: total %v, want %v", c.desc, c.p.Total, c.total)
+		}
+		if len(c.p.Funcs) != len(c.want) {
+			t.Errorf("%v: got %v functions, want %v", c.desc, len(c.p.Funcs), len(c.want))
+		}
+		for name, want := range c.want {
+			if f := c.p.Funcs[name]; f == nil || *f != want {
+				t.Errorf("%v: %v is %+v, want %+v", c.desc, name, f, want)
+			}
+		}
+	}
+}
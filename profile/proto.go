@@ -0,0 +1,214 @@
+package profile
+
+import (
+	"fmt"
+)
+
+// This file decodes the subset of profile.proto (github.com/google/pprof/proto)
+// that is needed to attribute sample values to functions.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type protoProfile struct {
+	sampleTypes []valueType
+	samples     []protoSample
+	locations   map[uint64][]uint64 // location id -> function ids, innermost first
+	functions   map[uint64]int64    // function id -> name index
+	strings     []string
+}
+
+type valueType struct {
+	typ  int64 // string index
+	unit int64 // string index
+}
+
+type protoSample struct {
+	locations []uint64 // leaf first
+	values    []int64
+}
+
+// decoder reads protobuf wire format.
+type decoder struct {
+	data []byte
+}
+
+func (d *decoder) varint() (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if len(d.data) == 0 {
+			return 0, fmt.Errorf("truncated varint")
+		}
+		b := d.data[0]
+		d.data = d.data[1:]
+		v |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("bad varint")
+}
+
+// field reads the next field. For length-delimited fields it returns
+// the contents, for numeric fields the value.
+func (d *decoder) field() (num int, wire int, v uint64, data []byte, err error) {
+	key, err := d.varint()
+	if err != nil {
+		return
+	}
+	num, wire = int(key>>3), int(key&7)
+	switch wire {
+	case wireVarint:
+		v, err = d.varint()
+	case wireFixed64:
+		if len(d.data) < 8 {
+			return 0, 0, 0, nil, fmt.Errorf("truncated fixed64")
+		}
+		d.data = d.data[8:]
+	case wireFixed32:
+		if len(d.data) < 4 {
+			return 0, 0, 0, nil, fmt.Errorf("truncated fixed32")
+		}
+		d.data = d.data[4:]
+	case wireBytes:
+		var n uint64
+		if n, err = d.varint(); err != nil {
+			return
+		}
+		if n > uint64(len(d.data)) {
+			return 0, 0, 0, nil, fmt.Errorf("truncated field %v", num)
+		}
+		data, d.data = d.data[:n], d.data[n:]
+	default:
+		err = fmt.Errorf("unsupported wire type %v", wire)
+	}
+	return
+}
+
+// repeated appends values of a repeated integer field, either packed or not.
+func repeated(dst []uint64, wire int, v uint64, data []byte) ([]uint64, error) {
+	if wire != wireBytes {
+		return append(dst, v), nil
+	}
+	d := &decoder{data}
+	for len(d.data) != 0 {
+		x, err := d.varint()
+		if err != nil {
+			return nil, err
+		}
+		dst = append(dst, x)
+	}
+	return dst, nil
+}
+
+func decodeProto(data []byte) (*protoProfile, error) {
+	p := &protoProfile{
+		locations: make(map[uint64][]uint64),
+		functions: make(map[uint64]int64),
+	}
+	d := &decoder{data}
+	for len(d.data) != 0 {
+		num, wire, _, data, err := d.field()
+		if err != nil {
+			return nil, err
+		}
+		if wire != wireBytes {
+			continue
+		}
+		switch num {
+		case 1:
+			var vt valueType
+			err = decodeMessage(data, func(num, wire int, v uint64, data []byte) error {
+				switch num {
+				case 1:
+					vt.typ = int64(v)
+				case 2:
+					vt.unit = int64(v)
+				}
+				return nil
+			})
+			p.sampleTypes = append(p.sampleTypes, vt)
+		case 2:
+			var s protoSample
+			var values []uint64
+			err = decodeMessage(data, func(num, wire int, v uint64, data []byte) (err error) {
+				switch num {
+				case 1:
+					s.locations, err = repeated(s.locations, wire, v, data)
+				case 2:
+					values, err = repeated(values, wire, v, data)
+				}
+				return
+			})
+			for _, v := range values {
+				s.values = append(s.values, int64(v))
+			}
+			p.samples = append(p.samples, s)
+		case 4:
+			var id uint64
+			var funcs []uint64
+			err = decodeMessage(data, func(num, wire int, v uint64, data []byte) error {
+				switch num {
+				case 1:
+					id = v
+				case 4:
+					return decodeMessage(data, func(num, wire int, v uint64, data []byte) error {
+						if num == 1 {
+							funcs = append(funcs, v)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			p.locations[id] = funcs
+		case 5:
+			var id uint64
+			var name int64
+			err = decodeMessage(data, func(num, wire int, v uint64, data []byte) error {
+				switch num {
+				case 1:
+					id = v
+				case 2:
+					name = int64(v)
+				}
+				return nil
+			})
+			p.functions[id] = name
+		case 6:
+			p.strings = append(p.strings, string(data))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(p.strings) == 0 || p.strings[0] != "" {
+		return nil, fmt.Errorf("bad string table")
+	}
+	return p, nil
+}
+
+func decodeMessage(data []byte, f func(num, wire int, v uint64, data []byte) error) error {
+	d := &decoder{data}
+	for len(d.data) != 0 {
+		num, wire, v, data, err := d.field()
+		if err != nil {
+			return err
+		}
+		if err := f(num, wire, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *protoProfile) str(i int64) string {
+	if i < 0 || i >= int64(len(p.strings)) {
+		return ""
+	}
+	return p.strings[i]
+}
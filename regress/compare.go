@@ -0,0 +1,121 @@
+package regress
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/repo"
+)
+
+// Comparison compares a series at two revisions.
+type Comparison struct {
+	Benchmark string
+	Machine   string
+	Metric    string
+	Procs     int
+	RevA      string // revision the old value comes from
+	RevB      string // revision the new value comes from
+	Old       float64
+	New       float64
+	Delta     float64 // relative change
+	Noise     float64 // relative noise of the series, 0 if there are too few results
+	// Significant is set if the change exceeds both the noise and the metric threshold.
+	Significant bool
+	Regression  bool // the change is for the worse
+}
+
+// Compare compares all series of the project at revisions a and b.
+// If a series has no result for a revision, the closest older result is used.
+// Series without results at or before both revisions are omitted.
+func Compare(cfg *config.ProjectConfig, s db.Store, revs []*repo.Rev, a, b *repo.Rev) ([]*Comparison, error) {
+	for _, rev := range []*repo.Rev{a, b} {
+		if !repo.In(revs, rev) {
+			return nil, fmt.Errorf("revision %v is not in the history", rev.Id)
+		}
+	}
+	results, err := s.Select(&db.Query{Project: cfg.Name})
+	if err != nil {
+		return nil, err
+	}
+	last := a.Index
+	if last < b.Index {
+		last = b.Index
+	}
+	revs = revs[:last+1]
+	series := make(map[seriesKey][]*db.Result)
+	for _, r := range results {
+		key := seriesKey{r.Benchmark, r.Machine, r.Metric, r.Procs}
+		series[key] = append(series[key], r)
+	}
+	index := make(map[string]int, len(revs))
+	for _, rev := range revs {
+		index[rev.Id] = rev.Index
+	}
+	var res []*Comparison
+	for key, ss := range series {
+		ss = Order(ss, revs)
+		ra, rb := at(ss, index, a.Index), at(ss, index, b.Index)
+		if ra == nil || rb == nil {
+			continue
+		}
+		m := cfg.Metric(key.Metric)
+		c := &Comparison{
+			Benchmark: key.Benchmark,
+			Machine:   key.Machine,
+			Metric:    key.Metric,
+			Procs:     key.Procs,
+			RevA:      ra.Rev,
+			RevB:      rb.Rev,
+			Old:       float64(ra.Value),
+			New:       float64(rb.Value),
+		}
+		if c.Old != 0 {
+			c.Delta = (c.New - c.Old) / c.Old
+		}
+		values := make([]float64, len(ss))
+		for i, r := range ss {
+			values[i] = float64(r.Value)
+		}
+		if noise, ok := Noise(values); ok {
+			c.Noise = noise
+			c.Significant = c.Old != 0 && math.Abs(c.Delta) > noise && math.Abs(c.Delta) >= m.Threshold
+		}
+		c.Regression = m.Worse(c.Old, c.New)
+		res = append(res, c)
+	}
+	sort.Sort(comparisonSlice(res))
+	return res, nil
+}
+
+// at returns the last result at or before the revision with index idx.
+func at(ordered []*db.Result, index map[string]int, idx int) *db.Result {
+	var res *db.Result
+	for _, r := range ordered {
+		if index[r.Rev] > idx {
+			break
+		}
+		res = r
+	}
+	return res
+}
+
+type comparisonSlice []*Comparison
+
+func (p comparisonSlice) Len() int      { return len(p) }
+func (p comparisonSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p comparisonSlice) Less(i, j int) bool {
+	a, b := p[i], p[j]
+	switch {
+	case a.Benchmark != b.Benchmark:
+		return a.Benchmark < b.Benchmark
+	case a.Machine != b.Machine:
+		return a.Machine < b.Machine
+	case a.Procs != b.Procs:
+		return a.Procs < b.Procs
+	default:
+		return a.Metric < b.Metric
+	}
+}
@@ -0,0 +1,129 @@
+// Package regress detects performance changes in series of benchmark results.
+//
+// A series (a metric of a benchmark on a machine with fixed GOMAXPROCS)
+// is ordered by revision. For every revision, the results in a window before it
+// are compared with the results in a window starting at it with the Mann-Whitney
+// U test. The test works on ranks, so it is not thrown off by outliers that are
+// common in noisy metrics like latency-99 and gc-pause-one. A revision is
+// a change point if the test is significant, the shift of medians exceeds
+// the minimal meaningful change and the noise within both windows,
+// and no neighbouring revision is a better candidate.
+package regress
+
+import (
+	"math"
+)
+
+const (
+	Window     = 10   // max number of results on each side of a change point
+	MinSamples = 6    // min number of results on each side of a change point
+	Alpha      = 0.01 // significance level
+	// DefaultMinChange is the minimal relative change of the median that is reported
+	// for metrics without Threshold.
+	DefaultMinChange = 0.02
+)
+
+// Point is a change point in a series.
+type Point struct {
+	Index int     // index of the first value after the change
+	Old   float64 // median before the change
+	New   float64 // median after the change
+	P     float64 // p-value
+
+	cost float64 // sum of absolute deviations from the medians of both windows
+}
+
+// Delta returns relative change of the median.
+func (p *Point) Delta() float64 {
+	if p.Old == 0 {
+		return 0
+	}
+	return (p.New - p.Old) / p.Old
+}
+
+// Detect returns change points in values.
+func Detect(values []float64, minChange float64) []Point {
+	var points []Point
+	var cur *Point // best candidate in the current run of candidates
+	for i := MinSamples; i <= len(values)-MinSamples; i++ {
+		p, ok := test(values, i, minChange)
+		if !ok {
+			if cur != nil {
+				points = append(points, *cur)
+				cur = nil
+			}
+			continue
+		}
+		if cur == nil || stronger(p, *cur) {
+			cur = &p
+		}
+	}
+	if cur != nil {
+		points = append(points, *cur)
+	}
+	// Windows that contain a step are still significant a few results later,
+	// so only the strongest point within Window results is kept.
+	var res []Point
+	for i, p := range points {
+		keep := true
+		for j, q := range points {
+			if i != j && p.Index-q.Index < Window && q.Index-p.Index < Window && stronger(q, p) {
+				keep = false
+			}
+		}
+		if keep {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// stronger returns whether p is a better change point than q.
+// All fully separated windows of the same size have the same p-value,
+// then the better split is the one that leaves less spread within the windows.
+func stronger(p, q Point) bool {
+	return p.P < q.P || p.P == q.P && p.cost < q.cost
+}
+
+// test checks whether there is a change point at index i.
+func test(values []float64, i int, minChange float64) (Point, bool) {
+	lo, hi := i-Window, i+Window
+	if lo < 0 {
+		lo = 0
+	}
+	if hi > len(values) {
+		hi = len(values)
+	}
+	before, after := values[lo:i], values[i:hi]
+	p := Point{Index: i, Old: median(before), New: median(after)}
+	if p.Old == 0 {
+		return p, false
+	}
+	shift := math.Abs(p.New - p.Old)
+	if shift/math.Abs(p.Old) < minChange || shift <= mad(before) || shift <= mad(after) {
+		return p, false
+	}
+	p.P = mannWhitney(before, after)
+	for _, v := range before {
+		p.cost += math.Abs(v - p.Old)
+	}
+	for _, v := range after {
+		p.cost += math.Abs(v - p.New)
+	}
+	return p, p.P < Alpha
+}
+
+// Noise returns the relative change between two results of the series
+// that is expected without any real change (about 95% of the time).
+// It returns false if the series is too short to estimate the noise.
+func Noise(values []float64) (float64, bool) {
+	if len(values) > 2*Window {
+		values = values[len(values)-2*Window:]
+	}
+	m := median(values)
+	if len(values) < MinSamples || m == 0 {
+		return 0, false
+	}
+	// A difference of two independent results has sqrt(2) larger deviation.
+	return 2 * math.Sqrt2 * mad(values) / math.Abs(m), true
+}
@@ -0,0 +1,147 @@
+package regress
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"code.google.com/p/goperfd/db"
+)
+
+// noisy returns n values around v with relative standard deviation sd.
+func noisy(r *rand.Rand, n int, v, sd float64) []float64 {
+	res := make([]float64, n)
+	for i := range res {
+		res[i] = v * (1 + sd*r.NormFloat64())
+	}
+	return res
+}
+
+func TestDetectStep(t *testing.T) {
+	// Noise decides which neighbouring windows are significant, so several series are tried.
+	for seed := int64(1); seed <= 10; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		for _, c := range []struct {
+			desc   string
+			values []float64
+			index  int
+		}{
+			{"clean step", append(noisy(r, 20, 100, 0), noisy(r, 20, 150, 0)...), 20},
+			{"noisy step", append(noisy(r, 20, 100, 0.01), noisy(r, 20, 130, 0.01)...), 20},
+			{"step down", append(noisy(r, 15, 100, 0.01), noisy(r, 25, 90, 0.01)...), 15},
+			{"short series", append(noisy(r, MinSamples, 100, 0), noisy(r, MinSamples, 150, 0)...), MinSamples},
+		} {
+			points := Detect(c.values, DefaultMinChange)
+			if len(points) != 1 || points[0].Index != c.index {
+				t.Errorf("%v (seed %v): got %+v, want a change at %v", c.desc, seed, points, c.index)
+				continue
+			}
+			p := points[0]
+			if want := c.values[c.index] > c.values[c.index-1]; p.New > p.Old != want || p.P >= Alpha {
+				t.Errorf("%v (seed %v): got %+v", c.desc, seed, p)
+			}
+		}
+	}
+}
+
+func TestDetectNoChange(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, c := range []struct {
+		desc   string
+		values []float64
+	}{
+		{"noise", noisy(r, 100, 100, 0.01)},
+		// Outliers are common in latency metrics.
+		{"outliers", append(append(noisy(r, 15, 100, 0.01), 1000, 1000), noisy(r, 15, 100, 0.01)...)},
+		{"below min change", append(noisy(r, 20, 100, 0.001), noisy(r, 20, 101, 0.001)...)},
+		{"shift within noise", append(noisy(r, 20, 100, 0.1), noisy(r, 20, 103, 0.1)...)},
+		{"zeros", make([]float64, 30)},
+		// Too short to have MinSamples on each side.
+		{"short series", append(noisy(r, MinSamples, 100, 0), noisy(r, MinSamples-1, 150, 0)...)},
+		{"empty", nil},
+	} {
+		if points := Detect(c.values, DefaultMinChange); len(points) != 0 {
+			t.Errorf("%v: got %+v, want no changes", c.desc, points)
+		}
+	}
+}
+
+func TestDetectTwoSteps(t *testing.T) {
+	for seed := int64(1); seed <= 10; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		values := append(append(noisy(r, 20, 100, 0.01), noisy(r, 20, 150, 0.01)...), noisy(r, 20, 100, 0.01)...)
+		points := Detect(values, DefaultMinChange)
+		if len(points) != 2 || points[0].Index != 20 || points[1].Index != 40 {
+			t.Errorf("seed %v: got %+v, want changes at 20 and 40", seed, points)
+		}
+	}
+}
+
+func TestMannWhitney(t *testing.T) {
+	for _, c := range []struct {
+		desc string
+		x, y []float64
+		p    float64
+	}{
+		// Ranks 1 3 3 5.5 against 3 5.5 7.5 7.5 9: U = 2.5, tie term 24+6+6,
+		// sigma = sqrt(20/12*(10-36/72)), z = (10-2.5-0.5)/sigma.
+		{"ties", []float64{1, 2, 2, 3}, []float64{2, 3, 4, 4, 5}, 0.0785458509511907},
+		// Two groups of 10 ties: sigma = sqrt(100/12*(21-1980/380)), z = 49.5/sigma.
+		{"separated ties", []float64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, []float64{2, 2, 2, 2, 2, 2, 2, 2, 2, 2}, 1.5937911688066275e-05},
+		{"all tied", []float64{5, 5, 5}, []float64{5, 5, 5, 5}, 1},
+		{"same", []float64{1, 2, 3}, []float64{1, 2, 3}, 1},
+		{"empty", nil, []float64{1, 2}, 1},
+	} {
+		p := mannWhitney(c.x, c.y)
+		if math.Abs(p-c.p) > 1e-9 {
+			t.Errorf("%v: got p=%v, want %v", c.desc, p, c.p)
+		}
+		if p1 := mannWhitney(c.y, c.x); math.Abs(p-p1) > 1e-12 {
+			t.Errorf("%v: p is not symmetric: %v vs %v", c.desc, p, p1)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	var ss []*db.Result
+	for _, rev := range []string{"r0", "r1", "r2", "r3", "r4", "r5", "r6", "r7", "r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15", "r16", "r17", "r18", "r19", "r20", "r21", "r22", "r23", "r24", "r25", "r26", "r27", "r28", "r29", "r30"} {
+		ss = append(ss, &db.Result{Rev: rev})
+	}
+	change := func(id, rev string) *db.Change {
+		return &db.Change{Id: id, Benchmark: "json", Machine: "m", Metric: "time", Procs: 1, Rev: rev}
+	}
+	other := change("other", "r10")
+	other.Metric = "rss"
+	old := []*db.Change{other, change("a", "r10"), change("b", "r13"), change("far", "r30")}
+	for _, c := range []struct {
+		desc string
+		revs []string
+		want []string // ids, "" for a new change
+	}{
+		{"same", []string{"r10"}, []string{"a"}},
+		{"moved", []string{"r12"}, []string{"b"}},
+		{"both moved", []string{"r9", "r14"}, []string{"a", "b"}},
+		{"closest first", []string{"r11", "r13"}, []string{"a", "b"}},
+		// Every previous change is given to one change point only.
+		{"one to one", []string{"r3", "r5", "r11"}, []string{"", "b", "a"}},
+		{"split", []string{"r7", "r8"}, []string{"b", "a"}},
+		{"edge of window", []string{"r0"}, []string{"a"}},
+		{"new", []string{"r29", "r30"}, []string{"", "far"}},
+		{"unknown revision", []string{"r31"}, []string{""}},
+	} {
+		var changes []*db.Change
+		for _, rev := range c.revs {
+			changes = append(changes, change("", rev))
+		}
+		got := match(old, changes, ss)
+		for i, want := range c.want {
+			id := ""
+			if got[i] != nil {
+				id = got[i].Id
+			}
+			if id != want {
+				t.Errorf("%v: change at %v matched %q, want %q", c.desc, c.revs[i], id, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package regress
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/repo"
+)
+
+// Detector periodically runs change point detection over all series
+// in the project and stores the detected changes.
+type Detector struct {
+	project *config.ProjectFile
+	repo    *repo.Repo
+	store   db.Store
+	// Notify, if set, is called for every newly detected change.
+	// It is called in a separate goroutine, so slow subscribers do not delay detection.
+	Notify func(cfg *config.ProjectConfig, c *db.Change)
+
+	mu sync.Mutex // serializes runs
+}
+
+func NewDetector(project *config.ProjectFile, r *repo.Repo, s db.Store) *Detector {
+	return &Detector{project: project, repo: r, store: s}
+}
+
+type seriesKey struct {
+	Benchmark string
+	Machine   string
+	Metric    string
+	Procs     int
+}
+
+// Poll runs detection every period.
+func (d *Detector) Poll(period time.Duration) {
+	for {
+		if _, err := d.Run(); err != nil {
+			log.Printf("regress: detection failed: %v", err)
+		}
+		time.Sleep(period)
+	}
+}
+
+// Run scans all series once and returns newly detected changes.
+// When more results arrive, already detected changes are narrowed down.
+func (d *Detector) Run() ([]*db.Change, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cfg := d.project.Get()
+	project := cfg.Name
+	results, err := d.store.Select(&db.Query{Project: project})
+	if err != nil {
+		return nil, err
+	}
+	old, err := d.store.Changes(project)
+	if err != nil {
+		return nil, err
+	}
+	revs := d.repo.Revs()
+	series := make(map[seriesKey][]*db.Result)
+	for _, r := range results {
+		key := seriesKey{r.Benchmark, r.Machine, r.Metric, r.Procs}
+		series[key] = append(series[key], r)
+	}
+	var found []*db.Change
+	for key, ss := range series {
+		ss = Order(ss, revs)
+		values := make([]float64, len(ss))
+		for i, r := range ss {
+			values[i] = float64(r.Value)
+		}
+		m := cfg.Metric(key.Metric)
+		minChange := DefaultMinChange
+		if m.Threshold != 0 {
+			minChange = m.Threshold
+		}
+		var changes []*db.Change
+		for _, p := range Detect(values, minChange) {
+			c := &db.Change{
+				Project:   project,
+				Benchmark: key.Benchmark,
+				Machine:   key.Machine,
+				Metric:    key.Metric,
+				Procs:     key.Procs,
+			}
+			c.Rev = ss[p.Index].Rev
+			c.Prev = ss[p.Index-1].Rev
+			c.Old = p.Old
+			c.New = p.New
+			c.P = p.P
+			c.Regression = m.Worse(p.Old, p.New)
+			c.Time = time.Now()
+			// Revisions that are adjacent in topological order are not necessarily
+			// related, e.g. the last commit of a merged branch and a commit on the main line.
+			if r := d.repo.Rev(c.Rev); r != nil && isParent(r, c.Prev) {
+				c.Culprit = c.Rev
+			}
+			changes = append(changes, c)
+		}
+		prevs := match(old, changes, ss)
+		for i, c := range changes {
+			prev := prevs[i]
+			if prev != nil && prev.Rev == c.Rev && prev.Prev == c.Prev {
+				continue
+			}
+			if prev != nil {
+				// More results have arrived and the change point has moved.
+				c.Id = prev.Id
+				c.Time = prev.Time
+			} else {
+				c.Id = fmt.Sprintf("%v-%v-%v-%v-%v-%v", c.Benchmark, c.Metric, c.Machine, c.Procs, c.Rev, c.Time.Unix())
+			}
+			if err := d.store.AddChange(c); err != nil {
+				return found, err
+			}
+			if c.Culprit != "" && (prev == nil || prev.Culprit == "") {
+				log.Printf("regress: culprit of change %v is %v", c.Id, c.Culprit)
+			}
+			if prev == nil {
+				log.Printf("regress: %v/%v on %v (procs %v) changed at %v: %.0f -> %.0f (p=%.4f)",
+					c.Benchmark, c.Metric, c.Machine, c.Procs, c.Rev, c.Old, c.New, c.P)
+				found = append(found, c)
+				if d.Notify != nil {
+					go d.Notify(cfg, c)
+				}
+			}
+		}
+	}
+	return found, nil
+}
+
+// isParent returns whether id is a parent of rev.
+func isParent(rev *repo.Rev, id string) bool {
+	for _, p := range rev.Parents {
+		if p == id {
+			return true
+		}
+	}
+	return false
+}
+
+// match finds previously detected changes in the same series that are
+// within Window results of changes, it returns them in the order of changes.
+// Every previous change is matched to at most one change, the closest ones
+// are matched first, so two nearby change points do not share an Id.
+func match(old []*db.Change, changes []*db.Change, ss []*db.Result) []*db.Change {
+	pos := make(map[string]int)
+	for i, r := range ss {
+		pos[r.Rev] = i
+	}
+	res := make([]*db.Change, len(changes))
+	used := make(map[*db.Change]bool)
+	for dist := 0; dist <= Window; dist++ {
+		for ci, c := range changes {
+			if res[ci] != nil {
+				continue
+			}
+			j, ok := pos[c.Rev]
+			if !ok {
+				continue
+			}
+			for _, o := range old {
+				if used[o] || o.Benchmark != c.Benchmark || o.Metric != c.Metric || o.Machine != c.Machine || o.Procs != c.Procs {
+					continue
+				}
+				if i, ok := pos[o.Rev]; ok && (i-j == dist || j-i == dist) {
+					res[ci] = o
+					used[o] = true
+					break
+				}
+			}
+		}
+	}
+	return res
+}
+
+// Order returns results that belong to revs sorted in revision order.
+func Order(results []*db.Result, revs []*repo.Rev) []*db.Result {
+	idx := make(map[string]int, len(revs))
+	for _, rev := range revs {
+		idx[rev.Id] = rev.Index
+	}
+	ordered := make([]*db.Result, len(revs))
+	for _, r := range results {
+		if i, ok := idx[r.Rev]; ok {
+			ordered[i] = r
+		}
+	}
+	var res []*db.Result
+	for _, r := range ordered {
+		if r != nil {
+			res = append(res, r)
+		}
+	}
+	return res
+}
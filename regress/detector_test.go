@@ -0,0 +1,118 @@
+package regress
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/repo"
+)
+
+func TestCulprit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "goperfd-regress-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gitDir := filepath.Join(dir, "repo")
+	os.Mkdir(gitDir, 0750)
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = gitDir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Gopher", "GIT_AUTHOR_EMAIL=gopher@example.com",
+			"GIT_COMMITTER_NAME=Gopher", "GIT_COMMITTER_EMAIL=gopher@example.com", "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	commit := func(msg string) {
+		git("commit", "--quiet", "--allow-empty", "-m", msg)
+	}
+	// A side branch is merged into the main line:
+	// A1 .. A6 -- A7 -- A8 -- M -- A9 .. A16
+	//          \              /
+	//           S1 ------ S2
+	// git orders it as A1 .. A8 S1 S2 M A9 .. A16, so S1 follows A8,
+	// but A8 is not its parent.
+	git("init", "--quiet")
+	git("checkout", "--quiet", "-b", "master")
+	for _, c := range []string{"A1", "A2", "A3", "A4", "A5", "A6"} {
+		commit(c)
+	}
+	git("checkout", "--quiet", "-b", "side")
+	commit("S1")
+	commit("S2")
+	git("checkout", "--quiet", "master")
+	commit("A7")
+	commit("A8")
+	git("merge", "--quiet", "--no-ff", "-m", "M", "side")
+	for _, c := range []string{"A9", "A10", "A11", "A12", "A13", "A14", "A15", "A16"} {
+		commit(c)
+	}
+	r, err := repo.Open(gitDir, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revs := r.Revs()
+	ids := make(map[string]string)
+	var order []string
+	for _, rev := range revs {
+		ids[rev.Desc] = rev.Id
+		order = append(order, rev.Desc)
+	}
+	if strings.Join(order, " ") != "A1 A2 A3 A4 A5 A6 A7 A8 S1 S2 M A9 A10 A11 A12 A13 A14 A15 A16" {
+		t.Skipf("unexpected topological order %v", order)
+	}
+
+	store, err := db.Open(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// Benchmark "side" changes at S1, benchmark "main" changes at A10.
+	var results []*db.Result
+	for i, rev := range revs {
+		side, main := uint64(100), uint64(100)
+		if i >= 8 {
+			side = 150
+		}
+		if i >= 12 {
+			main = 150
+		}
+		results = append(results,
+			&db.Result{Project: "test", Rev: rev.Id, Benchmark: "side", Machine: "m", Metric: "time", Procs: 1, Value: side},
+			&db.Result{Project: "test", Rev: rev.Id, Benchmark: "main", Machine: "m", Metric: "time", Procs: 1, Value: main})
+	}
+	if err := store.Add(results); err != nil {
+		t.Fatal(err)
+	}
+	project := new(config.ProjectFile)
+	project.Set(&config.ProjectConfig{Name: "test"})
+	found, err := NewDetector(project, r, store).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("found %v changes, want 2", len(found))
+	}
+	for _, c := range found {
+		switch c.Benchmark {
+		case "side":
+			if c.Rev != ids["S1"] || c.Prev != ids["A8"] || c.Culprit != "" {
+				t.Errorf("bad change in side: %+v", c)
+			}
+		case "main":
+			if c.Rev != ids["A10"] || c.Prev != ids["A9"] || c.Culprit != ids["A10"] {
+				t.Errorf("bad change in main: %+v", c)
+			}
+		}
+	}
+}
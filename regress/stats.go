@@ -0,0 +1,88 @@
+package regress
+
+import (
+	"math"
+	"sort"
+)
+
+func median(x []float64) float64 {
+	if len(x) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), x...)
+	sort.Float64s(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+// mad returns median absolute deviation of x scaled to be a consistent
+// estimator of standard deviation for normally distributed data.
+func mad(x []float64) float64 {
+	m := median(x)
+	d := make([]float64, len(x))
+	for i, v := range x {
+		d[i] = math.Abs(v - m)
+	}
+	return 1.4826 * median(d)
+}
+
+// mannWhitney returns two-sided p-value of the Mann-Whitney U test
+// for the hypothesis that x and y come from the same distribution.
+// It uses normal approximation with tie correction.
+func mannWhitney(x, y []float64) float64 {
+	n1, n2 := len(x), len(y)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	all := make([]sample, 0, n1+n2)
+	for _, v := range x {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range y {
+		all = append(all, sample{v, false})
+	}
+	sort.Sort(sampleSlice(all))
+	n := float64(n1 + n2)
+	r1, ties := 0.0, 0.0
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		// Samples i..j-1 are tied, they all get the average rank.
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].first {
+				r1 += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+	u := r1 - float64(n1*(n1+1))/2
+	mu := float64(n1*n2) / 2
+	sigma := math.Sqrt(float64(n1*n2) / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	z := (math.Abs(u-mu) - 0.5) / sigma
+	if z < 0 {
+		z = 0
+	}
+	return math.Erfc(z / math.Sqrt2)
+}
+
+type sample struct {
+	v     float64
+	first bool // belongs to the first set
+}
+
+type sampleSlice []sample
+
+func (p sampleSlice) Len() int           { return len(p) }
+func (p sampleSlice) Less(i, j int) bool { return p[i].v < p[j].v }
+func (p sampleSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -1,12 +1,232 @@
+// Package repo reads commit history from a local git clone.
 package repo
 
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
 type Rev struct {
-	Id string
-	Author string
-	Desc string
+	Id      string
+	Author  string
+	Desc    string
+	Time    time.Time // commit time
+	Parents []string
+	Index   int // position in topological order, the oldest revision has index 0
 }
 
+// Repo is a linearized history of a branch.
 type Repo struct {
+	Dir    string
+	Branch string
+
+	loadMu sync.Mutex // serializes load, it runs git without holding mu
+
+	mu   sync.RWMutex
+	revs []*Rev // in topological order, oldest first
+	ids  map[string]*Rev
+}
+
+// Open reads history of the branch from the git clone in dir.
+func Open(dir, branch string) (*Repo, error) {
+	if branch == "" {
+		branch = "HEAD"
+	}
+	r := &Repo{Dir: dir, Branch: branch, ids: make(map[string]*Rev)}
+	if _, err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Revs returns all revisions in topological order, oldest first.
+func (r *Repo) Revs() []*Rev {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Rev(nil), r.revs...)
 }
 
-//func (r *Repo) 
+// In returns whether rev is in revs, a result of Revs, at its Index.
+// Revisions returned by Rev, Head and Resolve can be missing from an earlier
+// snapshot or come from a different history if the branch was rewritten,
+// so their Index must be checked before it is used with a snapshot.
+func In(revs []*Rev, rev *Rev) bool {
+	return rev != nil && rev.Index < len(revs) && revs[rev.Index] == rev
+}
+
+// Rev returns the revision with the full id, or nil.
+func (r *Repo) Rev(id string) *Rev {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.ids[id]
+}
+
+// Head returns the newest revision, or nil if the branch is empty.
+func (r *Repo) Head() *Rev {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.revs) == 0 {
+		return nil
+	}
+	return r.revs[len(r.revs)-1]
+}
+
+// Resolve returns the revision named by a full or abbreviated id, a branch or a tag.
+// The revision must belong to the history of the branch. An empty name means the branch head.
+func (r *Repo) Resolve(name string) (*Rev, error) {
+	if name == "" {
+		if head := r.Head(); head != nil {
+			return head, nil
+		}
+		return nil, fmt.Errorf("branch %v is empty", r.Branch)
+	}
+	if rev := r.Rev(name); rev != nil {
+		return rev, nil
+	}
+	if strings.HasPrefix(name, "-") {
+		return nil, fmt.Errorf("bad revision name '%v'", name)
+	}
+	out, err := r.git("rev-parse", "--verify", "--quiet", name+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("unknown revision '%v'", name)
+	}
+	id := strings.TrimSpace(out)
+	rev := r.Rev(id)
+	if rev == nil {
+		return nil, fmt.Errorf("revision '%v' (%v) is not on branch %v", name, id, r.Branch)
+	}
+	return rev, nil
+}
+
+// Tags returns names of the tags in the repository keyed by the tagged revision.
+func (r *Repo) Tags() (map[string][]string, error) {
+	out, err := r.git("for-each-ref", "--format=%(objectname) %(*objectname) %(refname:short)", "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string][]string)
+	for _, ln := range strings.Split(out, "\n") {
+		f := strings.Fields(ln)
+		switch len(f) {
+		case 2: // lightweight tag
+			tags[f[0]] = append(tags[f[0]], f[1])
+		case 3: // annotated tag, the second field is the tagged commit
+			tags[f[1]] = append(tags[f[1]], f[2])
+		}
+	}
+	return tags, nil
+}
+
+// Update fetches from remotes (if any) and reads new revisions.
+// It returns the revisions that were not known before.
+func (r *Repo) Update() ([]*Rev, error) {
+	remotes, err := r.git("remote")
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(remotes) != "" {
+		if _, err := r.git("fetch", "--quiet"); err != nil {
+			// Still read the local branch, it could have been updated by other means.
+			log.Printf("repo: fetch in '%v' failed: %v", r.Dir, err)
+		}
+	}
+	return r.load()
+}
+
+// Poll periodically calls Update.
+func (r *Repo) Poll(period time.Duration) {
+	for {
+		time.Sleep(period)
+		revs, err := r.Update()
+		if err != nil {
+			log.Printf("repo: failed to update '%v': %v", r.Dir, err)
+			continue
+		}
+		if len(revs) != 0 {
+			log.Printf("repo: %v new revisions in '%v', head %v", len(revs), r.Dir, revs[len(revs)-1].Id)
+		}
+	}
+}
+
+// load reads revisions that are not yet known.
+// If the branch was rewritten, the whole history is re-read.
+func (r *Repo) load() ([]*Rev, error) {
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+	head := r.Head()
+	rng := r.Branch
+	if head != nil {
+		_, err := r.git("merge-base", "--is-ancestor", head.Id, r.Branch)
+		if err == nil {
+			rng = head.Id + ".." + r.Branch
+		} else {
+			log.Printf("repo: %v is not an ancestor of %v, re-reading history", head.Id, r.Branch)
+			head = nil
+		}
+	}
+	out, err := r.git("log", "--topo-order", "--reverse", "--format=%H%x1f%P%x1f%an <%ae>%x1f%ct%x1f%B%x1e", rng)
+	if err != nil {
+		return nil, err
+	}
+	var revs []*Rev
+	for _, rec := range strings.Split(out, "\x1e") {
+		rec = strings.TrimLeft(rec, "\n")
+		if rec == "" {
+			continue
+		}
+		rev, err := parseRev(rec)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if head == nil {
+		r.revs = nil
+		r.ids = make(map[string]*Rev)
+	}
+	for _, rev := range revs {
+		rev.Index = len(r.revs)
+		r.revs = append(r.revs, rev)
+		r.ids[rev.Id] = rev
+	}
+	return revs, nil
+}
+
+func parseRev(rec string) (*Rev, error) {
+	f := strings.SplitN(rec, "\x1f", 5)
+	if len(f) != 5 {
+		return nil, fmt.Errorf("bad git log record '%v'", rec)
+	}
+	t, err := strconv.ParseInt(f[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad commit time in '%v'", rec)
+	}
+	return &Rev{
+		Id:      f[0],
+		Parents: strings.Fields(f[1]),
+		Author:  f[2],
+		Time:    time.Unix(t, 0),
+		Desc:    strings.TrimSpace(f[4]),
+	}, nil
+}
+
+func (r *Repo) git(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.Dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %v failed: %v\n%v", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,240 @@
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testRepo is a temporary git repository with deterministic commit times.
+type testRepo struct {
+	t    *testing.T
+	dir  string
+	time int64
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "goperfd-repo-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &testRepo{t: t, dir: dir, time: 1400000000}
+	tr.git("init", "--quiet")
+	tr.git("checkout", "--quiet", "-b", "master")
+	return tr
+}
+
+func (tr *testRepo) git(args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = tr.dir
+	date := fmt.Sprintf("%v +0000", tr.time)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Gopher", "GIT_AUTHOR_EMAIL=gopher@example.com", "GIT_AUTHOR_DATE="+date,
+		"GIT_COMMITTER_NAME=Gopher", "GIT_COMMITTER_EMAIL=gopher@example.com", "GIT_COMMITTER_DATE="+date,
+		"GIT_CONFIG_NOSYSTEM=1", "HOME="+tr.dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		tr.t.Fatalf("git %v failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit commits a change of a file and returns the commit id.
+func (tr *testRepo) commit(msg string) string {
+	tr.time += 60
+	if err := ioutil.WriteFile(filepath.Join(tr.dir, msg), []byte(msg), 0600); err != nil {
+		tr.t.Fatal(err)
+	}
+	tr.git("add", msg)
+	tr.git("commit", "--quiet", "-m", msg)
+	return tr.git("rev-parse", "HEAD")
+}
+
+func (tr *testRepo) close() {
+	os.RemoveAll(tr.dir)
+}
+
+// checkOrder checks that indexes match positions and parents go before children.
+func checkOrder(t *testing.T, r *Repo) {
+	revs := r.Revs()
+	for i, rev := range revs {
+		if rev.Index != i {
+			t.Errorf("revision %v has index %v at position %v", rev.Id, rev.Index, i)
+		}
+		if r.Rev(rev.Id) != rev {
+			t.Errorf("Rev(%v) does not return the revision", rev.Id)
+		}
+		for _, p := range rev.Parents {
+			if pr := r.Rev(p); pr != nil && pr.Index >= rev.Index {
+				t.Errorf("parent %v of %v goes after it", p, rev.Id)
+			}
+		}
+	}
+}
+
+func TestRepo(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.close()
+	a := tr.commit("a")
+	tr.git("checkout", "--quiet", "-b", "feature")
+	c := tr.commit("c")
+	tr.git("checkout", "--quiet", "master")
+	b := tr.commit("b")
+	tr.time += 60
+	tr.git("merge", "--quiet", "--no-ff", "-m", "merge", "feature")
+	m := tr.git("rev-parse", "HEAD")
+	tr.git("tag", "v1", b)
+
+	r, err := Open(tr.dir, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revs := r.Revs()
+	if len(revs) != 4 || revs[0].Id != a || revs[3].Id != m {
+		t.Fatalf("bad revisions %v, want %v first and %v last", ids(revs), a, m)
+	}
+	checkOrder(t, r)
+	merge := r.Rev(m)
+	if len(merge.Parents) != 2 || merge.Parents[0] != b || merge.Parents[1] != c {
+		t.Errorf("merge parents are %v, want [%v %v]", merge.Parents, b, c)
+	}
+	if len(revs[0].Parents) != 0 {
+		t.Errorf("root has parents %v", revs[0].Parents)
+	}
+	if got, want := r.Rev(a).Time.Unix(), int64(1400000060); got != want {
+		t.Errorf("commit time of %v is %v, want %v", a, got, want)
+	}
+	if got, want := merge.Time.Unix(), int64(1400000240); got != want {
+		t.Errorf("commit time of %v is %v, want %v", m, got, want)
+	}
+	if merge.Desc != "merge" || merge.Author != "Gopher <gopher@example.com>" {
+		t.Errorf("bad merge description '%v' or author '%v'", merge.Desc, merge.Author)
+	}
+	if r.Head() != merge {
+		t.Errorf("head is %v, want %v", r.Head().Id, m)
+	}
+
+	for name, want := range map[string]string{
+		"":        m,
+		m:         m,
+		c[:8]:     c,
+		"v1":      b,
+		"master":  m,
+		"feature": c,
+	} {
+		rev, err := r.Resolve(name)
+		if err != nil {
+			t.Errorf("Resolve('%v') failed: %v", name, err)
+		} else if rev.Id != want {
+			t.Errorf("Resolve('%v') = %v, want %v", name, rev.Id, want)
+		}
+	}
+	for _, name := range []string{"nosuchrev", "-v", "0123456789"} {
+		if rev, err := r.Resolve(name); err == nil {
+			t.Errorf("Resolve('%v') = %v, want error", name, rev.Id)
+		}
+	}
+	tr.git("checkout", "--quiet", "feature")
+	off := tr.commit("off")
+	tr.git("checkout", "--quiet", "master")
+	if rev, err := r.Resolve(off); err == nil {
+		t.Errorf("Resolve of %v that is not on the branch = %v, want error", off, rev.Id)
+	}
+
+	tags, err := r.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags[b]) != 1 || tags[b][0] != "v1" {
+		t.Errorf("tags of %v are %v, want [v1]", b, tags[b])
+	}
+
+	newRevs, err := r.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(newRevs) != 0 {
+		t.Errorf("Update without commits returned %v", ids(newRevs))
+	}
+	d := tr.commit("d")
+	e := tr.commit("e")
+	newRevs, err = r.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(newRevs) != 2 || newRevs[0].Id != d || newRevs[1].Id != e {
+		t.Errorf("Update returned %v, want [%v %v]", ids(newRevs), d, e)
+	}
+	if len(r.Revs()) != 6 || r.Head().Id != e || r.Rev(e).Index != 5 {
+		t.Errorf("bad revisions after update %v", ids(r.Revs()))
+	}
+	checkOrder(t, r)
+}
+
+func TestConcurrentUpdate(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.close()
+	tr.commit("a")
+	r, err := Open(tr.dir, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		tr.commit(fmt.Sprintf("c%v", i))
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		total := 0
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				revs, err := r.Update()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				total += len(revs)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		if total != 1 {
+			t.Fatalf("concurrent updates returned %v new revisions, want 1", total)
+		}
+		if n := len(r.Revs()); n != i+2 {
+			t.Fatalf("have %v revisions, want %v", n, i+2)
+		}
+		checkOrder(t, r)
+	}
+}
+
+func ids(revs []*Rev) []string {
+	var res []string
+	for _, rev := range revs {
+		res = append(res, rev.Id)
+	}
+	return res
+}
+
+func TestIn(t *testing.T) {
+	a, b, c := &Rev{Id: "a", Index: 0}, &Rev{Id: "b", Index: 1}, &Rev{Id: "c", Index: 2}
+	revs := []*Rev{a, b}
+	if !In(revs, a) || !In(revs, b) {
+		t.Errorf("revisions of the snapshot are not in it")
+	}
+	if In(revs, c) || In(revs, nil) {
+		t.Errorf("a newer revision is in the snapshot")
+	}
+	if In(revs, &Rev{Id: "b2", Index: 1}) {
+		t.Errorf("a revision of a rewritten history is in the snapshot")
+	}
+}
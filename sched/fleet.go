@@ -0,0 +1,114 @@
+package sched
+
+import (
+	"sort"
+	"time"
+
+	"code.google.com/p/goperfd/config"
+)
+
+const (
+	// StuckTime is how long a job can go without heartbeats before it is considered stuck.
+	StuckTime = 10 * time.Minute
+	// SilentTime is how long a machine can go without any requests before it is flagged.
+	SilentTime = 30 * time.Minute
+	// maxOutcomes is the number of recent jobs the failure rate is computed over.
+	maxOutcomes = 50
+)
+
+type machineState struct {
+	lastSeen time.Time
+	outcomes []bool // recent jobs, true for failed ones
+}
+
+// MachineStatus describes the health of a builder machine.
+type MachineStatus struct {
+	Name     string
+	LastSeen time.Time // zero if the machine was not seen since goperfd start
+	Silent   bool      // the machine was not seen for SilentTime
+	Jobs     []*Lease  // running jobs
+	Stuck    []*Lease  // running jobs without heartbeats for StuckTime
+	Queue    int       // number of (revision, benchmark) pairs that are not benchmarked yet
+	Done     int       // number of recent successful jobs
+	Failed   int       // number of recent failed jobs
+}
+
+// FailureRate returns the fraction of failed jobs among recent ones.
+func (st *MachineStatus) FailureRate() float64 {
+	if st.Done+st.Failed == 0 {
+		return 0
+	}
+	return float64(st.Failed) / float64(st.Done+st.Failed)
+}
+
+// Status returns the status of all machines in the config.
+func (s *Scheduler) Status(cfg *config.ProjectConfig) ([]*MachineStatus, error) {
+	revs := s.window(cfg)
+	// Store scans are done without the lock, so that Status does not block builders.
+	have := make([][]map[string]bool, len(cfg.Machines))
+	for i, m := range cfg.Machines {
+		for _, b := range cfg.Benchmarks {
+			h, err := s.benchmarked(cfg, b.Name, m.Name)
+			if err != nil {
+				return nil, err
+			}
+			have[i] = append(have[i], h)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	now := time.Now()
+	var res []*MachineStatus
+	for i, m := range cfg.Machines {
+		st := &MachineStatus{Name: m.Name}
+		if ms := s.machines[m.Name]; ms != nil {
+			st.LastSeen = ms.lastSeen
+			for _, failed := range ms.outcomes {
+				if failed {
+					st.Failed++
+				} else {
+					st.Done++
+				}
+			}
+		}
+		st.Silent = now.Sub(st.LastSeen) > SilentTime
+		for _, l := range s.leases {
+			if l.Machine != m.Name {
+				continue
+			}
+			l1 := *l
+			st.Jobs = append(st.Jobs, &l1)
+			if now.Sub(l.Heartbeat) > StuckTime {
+				st.Stuck = append(st.Stuck, &l1)
+			}
+		}
+		sort.Sort(leaseSlice(st.Jobs))
+		sort.Sort(leaseSlice(st.Stuck))
+		for j, b := range cfg.Benchmarks {
+			for _, d := range s.markDone(revs, have[i][j], b.Name, m.Name) {
+				if !d {
+					st.Queue++
+				}
+			}
+		}
+		res = append(res, st)
+	}
+	return res, nil
+}
+
+func (s *Scheduler) machine(name string) *machineState {
+	ms := s.machines[name]
+	if ms == nil {
+		ms = new(machineState)
+		s.machines[name] = ms
+	}
+	return ms
+}
+
+func (ms *machineState) outcome(failed bool) {
+	ms.outcomes = append(ms.outcomes, failed)
+	if len(ms.outcomes) > maxOutcomes {
+		ms.outcomes = ms.outcomes[len(ms.outcomes)-maxOutcomes:]
+	}
+}
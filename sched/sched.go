@@ -0,0 +1,413 @@
+// Package sched decides what builders should benchmark next.
+//
+// For every benchmark, a machine first benchmarks the newest revision,
+// then the oldest one, and then fills the largest gap between already
+// benchmarked revisions by binary subdivision. Handed out jobs are leased
+// until the builder reports back or the lease expires, so the same
+// (revision, benchmark, machine) tuple is never given to two builders at once.
+// Builders extend leases of running jobs with heartbeats.
+//
+// Regressions take precedence: if a regression is detected between two
+// benchmarked revisions that are not adjacent, the machine benchmarks
+// the midpoint of the range, and so on until the culprit is found.
+package sched
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"code.google.com/p/goperfd/builder"
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/repo"
+)
+
+const (
+	DefaultLeaseTime = 3 * time.Hour
+	maxFailures      = 3 // a job that fails that many times is not retried
+	maxRescans       = 3 // store scans outside of the lock in Next
+)
+
+type Key struct {
+	Rev       string
+	Benchmark string
+	Machine   string
+}
+
+type Lease struct {
+	Key
+	Job       *builder.Job
+	Expire    time.Time
+	Started   time.Time
+	Heartbeat time.Time // time of the last heartbeat, or Started
+	Status    string    // as reported in the last heartbeat
+	Progress  float64
+	Bisect    bool // the job narrows down the range of a regression
+}
+
+type Scheduler struct {
+	LeaseTime time.Duration
+
+	repo  *repo.Repo
+	store db.Store
+
+	mu       sync.Mutex
+	leases   map[Key]*Lease
+	jobs     map[string]*Lease // by job id
+	failures map[Key]int
+	machines map[string]*machineState
+	gen      int // incremented by Done
+}
+
+func New(r *repo.Repo, s db.Store) *Scheduler {
+	return &Scheduler{
+		LeaseTime: DefaultLeaseTime,
+		repo:      r,
+		store:     s,
+		leases:    make(map[Key]*Lease),
+		jobs:      make(map[string]*Lease),
+		failures:  make(map[Key]int),
+		machines:  make(map[string]*machineState),
+	}
+}
+
+// Next chooses the next job for the machine and leases it.
+// It returns nil if there is nothing to do.
+func (s *Scheduler) Next(cfg *config.ProjectConfig, m *config.Machine) (*builder.Job, error) {
+	s.mu.Lock()
+	s.machine(m.Name).lastSeen = time.Now()
+	s.mu.Unlock()
+	revs := s.window(cfg)
+	if len(revs) == 0 {
+		return nil, nil
+	}
+	// Store scans are done without the lock, so that Next does not block
+	// heartbeats and other builders. A job that is completed meanwhile is
+	// not in the scan and is not leased anymore, so then the scan is repeated.
+	var snap *snapshot
+	var err error
+	for try := 0; ; try++ {
+		s.mu.Lock()
+		gen := s.gen
+		if try == maxRescans {
+			// Builders keep completing jobs, scan under the lock.
+			snap, err = s.scan(cfg, m.Name)
+			break
+		}
+		s.mu.Unlock()
+		snap, err = s.scan(cfg, m.Name)
+		s.mu.Lock()
+		if err != nil || s.gen == gen {
+			break
+		}
+		s.mu.Unlock()
+	}
+	defer s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	s.expire()
+	best, bestRev := s.bisect(cfg, revs, m, snap)
+	bisect := best != nil
+	if !bisect {
+		bestPrio := 0
+		for i := range cfg.Benchmarks {
+			b := &cfg.Benchmarks[i]
+			done := s.markDone(revs, snap.have[b.Name], b.Name, m.Name)
+			if idx, prio := choose(done); prio > bestPrio {
+				best, bestRev, bestPrio = b, idx, prio
+			}
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	id, err := newId()
+	if err != nil {
+		return nil, err
+	}
+	procs := m.Procs
+	if len(procs) == 0 {
+		procs = []int{1}
+	}
+	job := &builder.Job{
+		Id:        id,
+		Rev:       revs[bestRev].Id,
+		Benchmark: best.Name,
+		Procs:     procs,
+		Flags:     append(append([]string(nil), best.Flags...), m.Flags...),
+	}
+	now := time.Now()
+	l := &Lease{
+		Key:       Key{job.Rev, job.Benchmark, m.Name},
+		Job:       job,
+		Expire:    now.Add(s.LeaseTime),
+		Started:   now,
+		Heartbeat: now,
+		Bisect:    bisect,
+	}
+	s.leases[l.Key] = l
+	s.jobs[job.Id] = l
+	return job, nil
+}
+
+// Check returns builder.ErrUnknownJob unless the report is for a job
+// that is leased to the machine and matches the job.
+func (s *Scheduler) Check(m *config.Machine, rep *builder.Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	l := s.jobs[rep.Job]
+	if l == nil || l.Key != (Key{rep.Rev, rep.Benchmark, m.Name}) {
+		log.Printf("sched: rejected report from '%v' for unknown or expired job %v (%v@%v)", m.Name, rep.Job, rep.Benchmark, rep.Rev)
+		return builder.ErrUnknownJob
+	}
+	for _, run := range rep.Runs {
+		found := false
+		for _, p := range l.Job.Procs {
+			if p == run.Procs {
+				found = true
+			}
+		}
+		if !found {
+			log.Printf("sched: rejected report from '%v' for job %v: GOMAXPROCS %v was not requested", m.Name, rep.Job, run.Procs)
+			return builder.ErrUnknownJob
+		}
+	}
+	return nil
+}
+
+// Done releases the lease for the reported job.
+// The report must be already checked (see Check) and its results stored,
+// otherwise the job can be handed out again.
+func (s *Scheduler) Done(m *config.Machine, rep *builder.Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ms := s.machine(m.Name)
+	ms.lastSeen = time.Now()
+	ms.outcome(rep.Error != "")
+	s.gen++
+	key := Key{rep.Rev, rep.Benchmark, m.Name}
+	if l := s.jobs[rep.Job]; l == nil || l.Key != key {
+		log.Printf("sched: report for unknown or expired job %v (%+v)", rep.Job, key)
+	}
+	if l := s.leases[key]; l != nil {
+		delete(s.leases, key)
+		delete(s.jobs, l.Job.Id)
+	}
+	if rep.Error != "" {
+		s.failures[key]++
+		if s.failures[key] == maxFailures {
+			log.Printf("sched: giving up on %+v after %v failures", key, maxFailures)
+		}
+	} else {
+		delete(s.failures, key)
+	}
+}
+
+// Lease returns a copy of the lease of the job, or nil if the job is unknown or expired.
+func (s *Scheduler) Lease(job string) *Lease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.jobs[job]
+	if l == nil {
+		return nil
+	}
+	l1 := *l
+	return &l1
+}
+
+// Heartbeat records that the machine is alive and extends the lease of its current job.
+func (s *Scheduler) Heartbeat(m *config.Machine, hb *builder.Heartbeat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.machine(m.Name).lastSeen = now
+	if hb.Job == "" {
+		return
+	}
+	l := s.jobs[hb.Job]
+	if l == nil || l.Machine != m.Name {
+		log.Printf("sched: heartbeat from '%v' for unknown or expired job %v", m.Name, hb.Job)
+		return
+	}
+	l.Expire = now.Add(s.LeaseTime)
+	l.Heartbeat = now
+	l.Status = hb.Status
+	l.Progress = hb.Progress
+}
+
+// Leases returns copies of all active leases.
+func (s *Scheduler) Leases() []*Lease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	var res []*Lease
+	for _, l := range s.leases {
+		l1 := *l
+		res = append(res, &l1)
+	}
+	sort.Sort(leaseSlice(res))
+	return res
+}
+
+func (s *Scheduler) expire() {
+	now := time.Now()
+	for key, l := range s.leases {
+		if now.After(l.Expire) {
+			log.Printf("sched: lease for job %v (%+v) has expired", l.Job.Id, key)
+			delete(s.leases, key)
+			delete(s.jobs, l.Job.Id)
+		}
+	}
+}
+
+// window returns revisions that need to be benchmarked, oldest first.
+func (s *Scheduler) window(cfg *config.ProjectConfig) []*repo.Rev {
+	revs := s.repo.Revs()
+	if start := cfg.Start; start != "" {
+		rev := s.repo.Rev(start)
+		switch {
+		case rev == nil:
+			log.Printf("sched: unknown start revision '%v'", start)
+		case repo.In(revs, rev):
+			revs = revs[rev.Index:]
+		default:
+			// The history has just been re-read, try again next time.
+			return nil
+		}
+	}
+	return revs
+}
+
+// snapshot is the part of the store that Next works on.
+type snapshot struct {
+	changes []*db.Change
+	have    map[string]map[string]bool // benchmark -> benchmarked revisions
+}
+
+// scan reads the snapshot for the machine from the store. It does not need s.mu.
+func (s *Scheduler) scan(cfg *config.ProjectConfig, machine string) (*snapshot, error) {
+	changes, err := s.store.Changes(cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	snap := &snapshot{changes: changes, have: make(map[string]map[string]bool)}
+	for _, b := range cfg.Benchmarks {
+		if snap.have[b.Name], err = s.benchmarked(cfg, b.Name, machine); err != nil {
+			return nil, err
+		}
+	}
+	return snap, nil
+}
+
+// benchmarked returns ids of revisions that have results of the benchmark on the machine.
+// It does not need s.mu.
+func (s *Scheduler) benchmarked(cfg *config.ProjectConfig, bench, machine string) (map[string]bool, error) {
+	results, err := s.store.Select(&db.Query{Project: cfg.Name, Benchmark: bench, Machine: machine})
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]bool)
+	for _, r := range results {
+		have[r.Rev] = true
+	}
+	return have, nil
+}
+
+// markDone returns whether each revision in revs is benchmarked, leased or failed
+// for the benchmark on the machine, given the benchmarked revisions.
+func (s *Scheduler) markDone(revs []*repo.Rev, have map[string]bool, bench, machine string) []bool {
+	done := make([]bool, len(revs))
+	for i, rev := range revs {
+		key := Key{rev.Id, bench, machine}
+		done[i] = have[rev.Id] || s.leases[key] != nil || s.failures[key] >= maxFailures
+	}
+	return done
+}
+
+// bisect chooses a revision that narrows down the range of a regression
+// detected on the machine. It returns the benchmark and the index in revs,
+// or nil benchmark if there is no regression to bisect.
+func (s *Scheduler) bisect(cfg *config.ProjectConfig, revs []*repo.Rev, m *config.Machine, snap *snapshot) (*config.Benchmark, int) {
+	first := revs[0].Index
+next:
+	for _, c := range snap.changes {
+		if c.Machine != m.Name || !c.Regression || c.Culprit != "" {
+			continue
+		}
+		var b *config.Benchmark
+		for i := range cfg.Benchmarks {
+			if cfg.Benchmarks[i].Name == c.Benchmark {
+				b = &cfg.Benchmarks[i]
+			}
+		}
+		prev, rev := s.repo.Rev(c.Prev), s.repo.Rev(c.Rev)
+		if b == nil || prev == nil || rev == nil || prev.Index < first || rev.Index-prev.Index <= 1 {
+			continue
+		}
+		lo, hi := prev.Index-first, rev.Index-first
+		if hi >= len(revs) || revs[lo] != prev || revs[hi] != rev {
+			// Not in the snapshot of the history.
+			continue
+		}
+		// Wait for the running bisection job, its result determines the next step.
+		for _, r := range revs[lo+1 : hi] {
+			if s.leases[Key{r.Id, b.Name, m.Name}] != nil {
+				continue next
+			}
+		}
+		done := s.markDone(revs, snap.have[b.Name], b.Name, m.Name)
+		if idx, prio := choose(done[lo : hi+1]); prio != 0 {
+			log.Printf("sched: bisecting change %v between %v and %v", c.Id, c.Prev, c.Rev)
+			return b, lo + idx
+		}
+	}
+	return nil, 0
+}
+
+// choose returns index of the next revision to benchmark and its priority,
+// or priority 0 if all revisions are done.
+func choose(done []bool) (int, int) {
+	n := len(done)
+	switch {
+	case n == 0:
+		return -1, 0
+	case !done[n-1]:
+		return n - 1, n + 2
+	case !done[0]:
+		return 0, n + 1
+	}
+	idx, prio := -1, 0
+	prev := 0
+	for i := 1; i < n; i++ {
+		if !done[i] {
+			continue
+		}
+		if gap := i - prev - 1; gap > prio {
+			idx, prio = (prev+i)/2, gap
+		}
+		prev = i
+	}
+	return idx, prio
+}
+
+func newId() (string, error) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %v", err)
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// leaseSlice sorts leases by expiration time.
+type leaseSlice []*Lease
+
+func (p leaseSlice) Len() int           { return len(p) }
+func (p leaseSlice) Less(i, j int) bool { return p[i].Expire.Before(p[j].Expire) }
+func (p leaseSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,196 @@
+package sched
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/goperfd/builder"
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/repo"
+)
+
+// newTestScheduler creates a scheduler over a git repository with n linear commits.
+func newTestScheduler(t *testing.T, n int) (*Scheduler, *repo.Repo, db.Store, func()) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "goperfd-sched-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = filepath.Join(dir, "repo")
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Gopher", "GIT_AUTHOR_EMAIL=gopher@example.com",
+			"GIT_COMMITTER_NAME=Gopher", "GIT_COMMITTER_EMAIL=gopher@example.com", "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	os.Mkdir(filepath.Join(dir, "repo"), 0750)
+	git("init", "--quiet")
+	for i := 0; i < n; i++ {
+		git("commit", "--quiet", "--allow-empty", "-m", fmt.Sprintf("commit %v", i))
+	}
+	r, err := repo.Open(filepath.Join(dir, "repo"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := db.Open(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(r, store), r, store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+var (
+	testMachine = &config.Machine{Name: "m1", Key: "key", Procs: []int{1, 4}}
+	testConfig  = &config.ProjectConfig{
+		Name:       "test",
+		Benchmarks: []config.Benchmark{{Name: "json"}},
+		Machines:   []config.Machine{*testMachine, {Name: "m2", Key: "key"}},
+	}
+)
+
+// complete stores a result for the job and releases its lease.
+func complete(t *testing.T, s *Scheduler, store db.Store, m *config.Machine, job *builder.Job) {
+	rep := &builder.Report{Job: job.Id, Rev: job.Rev, Benchmark: job.Benchmark,
+		Runs: []builder.Run{{Procs: 1, Metrics: map[string]uint64{"time": 1}}}}
+	if err := s.Check(m, rep); err != nil {
+		t.Fatalf("report for job %v rejected: %v", job.Id, err)
+	}
+	res := &db.Result{Project: testConfig.Name, Rev: job.Rev, Benchmark: job.Benchmark, Machine: m.Name, Metric: "time", Procs: 1, Value: 1}
+	if err := store.Add([]*db.Result{res}); err != nil {
+		t.Fatal(err)
+	}
+	s.Done(m, rep)
+}
+
+func TestOrder(t *testing.T) {
+	s, r, store, cleanup := newTestScheduler(t, 9)
+	defer cleanup()
+	revs := r.Revs()
+	// Newest, oldest, then binary subdivision.
+	for _, want := range []int{8, 0, 4, 2, 6, 1, 3, 5, 7} {
+		job, err := s.Next(testConfig, testMachine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job == nil || job.Rev != revs[want].Id {
+			t.Fatalf("got job %+v, want revision #%v", job, want)
+		}
+		complete(t, s, store, testMachine, job)
+	}
+	if job, err := s.Next(testConfig, testMachine); err != nil || job != nil {
+		t.Fatalf("got job %+v, %v when everything is done", job, err)
+	}
+	st, err := s.Status(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st[0].Queue != 0 || st[0].Done != 9 || st[0].LastSeen.IsZero() {
+		t.Errorf("bad status of %v: %+v", testMachine.Name, st[0])
+	}
+	if st[1].Queue != 9 || !st[1].Silent {
+		t.Errorf("bad status of %v: %+v", st[1].Name, st[1])
+	}
+}
+
+func TestCheck(t *testing.T) {
+	s, _, _, cleanup := newTestScheduler(t, 3)
+	defer cleanup()
+	job, err := s.Next(testConfig, testMachine)
+	if err != nil || job == nil {
+		t.Fatalf("no job: %v", err)
+	}
+	if s.Lease(job.Id) == nil {
+		t.Fatalf("job %v is not leased", job.Id)
+	}
+	other := &config.Machine{Name: "m2", Key: "key"}
+	good := builder.Report{Job: job.Id, Rev: job.Rev, Benchmark: job.Benchmark,
+		Runs: []builder.Run{{Procs: 1}, {Procs: 4}}}
+	bad := []struct {
+		m   *config.Machine
+		rep builder.Report
+	}{
+		{other, good},
+		{testMachine, builder.Report{Job: "nosuchjob", Rev: job.Rev, Benchmark: job.Benchmark}},
+		{testMachine, builder.Report{Job: job.Id, Rev: "nosuchrev", Benchmark: job.Benchmark}},
+		{testMachine, builder.Report{Job: job.Id, Rev: job.Rev, Benchmark: "garbage"}},
+		{testMachine, builder.Report{Job: job.Id, Rev: job.Rev, Benchmark: job.Benchmark, Runs: []builder.Run{{Procs: 2}}}},
+	}
+	for i, b := range bad {
+		if err := s.Check(b.m, &b.rep); err != builder.ErrUnknownJob {
+			t.Errorf("report #%v: got %v, want ErrUnknownJob", i, err)
+		}
+	}
+	if err := s.Check(testMachine, &good); err != nil {
+		t.Fatalf("good report rejected: %v", err)
+	}
+	s.Done(testMachine, &good)
+	if err := s.Check(testMachine, &good); err != builder.ErrUnknownJob {
+		t.Errorf("report after Done: got %v, want ErrUnknownJob", err)
+	}
+	s.LeaseTime = -time.Second
+	job2, err := s.Next(testConfig, testMachine)
+	if err != nil || job2 == nil {
+		t.Fatalf("no job: %v", err)
+	}
+	rep := &builder.Report{Job: job2.Id, Rev: job2.Rev, Benchmark: job2.Benchmark}
+	if err := s.Check(testMachine, rep); err != builder.ErrUnknownJob {
+		t.Errorf("report for expired job: got %v, want ErrUnknownJob", err)
+	}
+}
+
+// hookStore calls hook once after the first Select.
+type hookStore struct {
+	db.Store
+	hook func()
+}
+
+func (s *hookStore) Select(q *db.Query) ([]*db.Result, error) {
+	res, err := s.Store.Select(q)
+	if h := s.hook; h != nil {
+		s.hook = nil
+		h()
+	}
+	return res, err
+}
+
+func TestCompleteDuringNext(t *testing.T) {
+	s, r, store, cleanup := newTestScheduler(t, 3)
+	defer cleanup()
+	revs := r.Revs()
+	job, err := s.Next(testConfig, testMachine)
+	if err != nil || job == nil || job.Rev != revs[2].Id {
+		t.Fatalf("got job %+v, %v, want revision #2", job, err)
+	}
+	// The job is completed while Next scans the store. The scheduler lock
+	// must not be held during the scan, otherwise this deadlocks.
+	hs := &hookStore{Store: store}
+	hs.hook = func() {
+		s.Heartbeat(testMachine, &builder.Heartbeat{Job: job.Id})
+		complete(t, s, store, testMachine, job)
+	}
+	s.store = hs
+	job2, err := s.Next(testConfig, testMachine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hs.hook != nil {
+		t.Fatalf("store was not scanned")
+	}
+	if job2 == nil || job2.Rev != revs[0].Id {
+		t.Fatalf("got job %+v, want revision #0", job2)
+	}
+}
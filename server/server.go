@@ -0,0 +1,115 @@
+// Package server implements the builder backend of goperfd: it hands out jobs
+// decided by the scheduler and stores results reported by builders.
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"code.google.com/p/goperfd/blob"
+	"code.google.com/p/goperfd/builder"
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/regress"
+	"code.google.com/p/goperfd/repo"
+	"code.google.com/p/goperfd/sched"
+)
+
+// Server implements builder.Backend.
+type Server struct {
+	store    db.Store
+	blobs    *blob.Store
+	repo     *repo.Repo
+	sched    *sched.Scheduler
+	detector *regress.Detector
+}
+
+// New creates a server for a single project.
+// The database and the blob store may be shared with other projects.
+func New(store db.Store, blobs *blob.Store, rp *repo.Repo, sc *sched.Scheduler, detector *regress.Detector) *Server {
+	return &Server{store: store, blobs: blobs, repo: rp, sched: sc, detector: detector}
+}
+
+func (s *Server) NextJob(cfg *config.ProjectConfig, m *config.Machine) (*builder.Job, error) {
+	job, err := s.sched.Next(cfg, m)
+	if err != nil || job == nil {
+		return nil, err
+	}
+	log.Printf("job %v (%v@%v) started on '%v'", job.Id, job.Benchmark, job.Rev, m.Name)
+	return job, nil
+}
+
+func (s *Server) Heartbeat(cfg *config.ProjectConfig, m *config.Machine, hb *builder.Heartbeat) error {
+	s.sched.Heartbeat(m, hb)
+	return nil
+}
+
+func (s *Server) Complete(cfg *config.ProjectConfig, m *config.Machine, rep *builder.Report) error {
+	if err := s.sched.Check(m, rep); err != nil {
+		return err
+	}
+	if s.repo.Rev(rep.Rev) == nil {
+		return fmt.Errorf("unknown revision %v", rep.Rev)
+	}
+	if rep.Error != "" {
+		log.Printf("job %v (%v@%v) failed on '%v': %v", rep.Job, rep.Benchmark, rep.Rev, m.Name, rep.Error)
+		s.sched.Done(m, rep)
+		return nil
+	}
+	now := time.Now()
+	var results []*db.Result
+	for _, run := range rep.Runs {
+		for metric, v := range run.Metrics {
+			results = append(results, &db.Result{
+				Project:   cfg.Name,
+				Rev:       rep.Rev,
+				Benchmark: rep.Benchmark,
+				Machine:   m.Name,
+				Metric:    metric,
+				Procs:     run.Procs,
+				Value:     v,
+				Time:      now,
+			})
+		}
+	}
+	if err := s.store.Add(results); err != nil {
+		return err
+	}
+	for _, run := range rep.Runs {
+		for name, data := range run.Files {
+			hash, err := s.blobs.Put(data)
+			if err != nil {
+				return err
+			}
+			f := &db.File{
+				Project:   cfg.Name,
+				Rev:       rep.Rev,
+				Benchmark: rep.Benchmark,
+				Machine:   m.Name,
+				Procs:     run.Procs,
+				Name:      name,
+				Hash:      hash,
+				Size:      int64(len(data)),
+				Time:      now,
+			}
+			if err := s.store.AddFile(f); err != nil {
+				return err
+			}
+		}
+	}
+	if l := s.sched.Lease(rep.Job); l != nil && l.Bisect {
+		// Narrow down the regression in background, but release the lease only
+		// after that, so that the next bisection step uses the new result.
+		go func() {
+			if _, err := s.detector.Run(); err != nil {
+				log.Printf("detection failed: %v", err)
+			}
+			s.sched.Done(m, rep)
+		}()
+	} else {
+		s.sched.Done(m, rep)
+	}
+	log.Printf("job %v (%v@%v) finished on '%v': %v results", rep.Job, rep.Benchmark, rep.Rev, m.Name, len(results))
+	return nil
+}
@@ -0,0 +1,168 @@
+package ui
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/regress"
+	"code.google.com/p/goperfd/repo"
+)
+
+// chart is a server-side rendered plot of a metric against revision order,
+// one line per machine and GOMAXPROCS value.
+type chart struct {
+	Title  string
+	URL    string // link to the detailed chart, empty for the detailed chart itself
+	Width  int
+	Height int
+	Left   int // plot area bounds
+	Right  int
+	Top    int
+	Bottom int
+	Lines  []*line
+	YTicks []tick
+	Rows   []*row // revisions with results, newest first
+}
+
+type line struct {
+	Name   string
+	Color  string
+	Path   string
+	Points []*point
+}
+
+type point struct {
+	X     float64
+	Y     float64
+	Href  string
+	Title string
+}
+
+type tick struct {
+	Y     float64
+	Label string
+}
+
+type row struct {
+	Rev    *repo.Rev
+	Values []string // per line
+}
+
+var colors = []string{"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f"}
+
+func makeChart(title, url string, m *config.Metric, results []*db.Result, revs []*repo.Rev, width, height int) *chart {
+	c := &chart{
+		Title:  title,
+		URL:    url,
+		Width:  width,
+		Height: height,
+		Left:   80,
+		Right:  width - 10,
+		Top:    10,
+		Bottom: height - 20,
+	}
+	byId := make(map[string]*repo.Rev, len(revs))
+	for _, rev := range revs {
+		byId[rev.Id] = rev
+	}
+	groups := make(map[lineKey][]*db.Result)
+	for _, r := range results {
+		k := lineKey{r.Machine, r.Procs}
+		groups[k] = append(groups[k], r)
+	}
+	var keys []lineKey
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Sort(lineKeySlice(keys))
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	first, last := len(revs), -1
+	ordered := make([][]*db.Result, len(keys))
+	for i, k := range keys {
+		ordered[i] = regress.Order(groups[k], revs)
+		for _, r := range ordered[i] {
+			lo = math.Min(lo, float64(r.Value))
+			hi = math.Max(hi, float64(r.Value))
+			idx := byId[r.Rev].Index
+			if first > idx {
+				first = idx
+			}
+			if last < idx {
+				last = idx
+			}
+		}
+	}
+	if last < 0 {
+		return c
+	}
+	pad := (hi - lo) * 0.05
+	if pad == 0 {
+		pad = math.Max(1, hi*0.05)
+	}
+	lo, hi = math.Max(0, lo-pad), hi+pad
+	xscale := float64(c.Right-c.Left) / math.Max(1, float64(last-first))
+	yscale := float64(c.Bottom-c.Top) / (hi - lo)
+	for i := 0; i <= 4; i++ {
+		v := lo + (hi-lo)*float64(i)/4
+		c.YTicks = append(c.YTicks, tick{float64(c.Bottom) - (v-lo)*yscale, m.Format(v)})
+	}
+
+	rows := make(map[int]*row)
+	for i, k := range keys {
+		l := &line{Name: k.Machine, Color: colors[i%len(colors)]}
+		if k.Procs != 1 {
+			l.Name = fmt.Sprintf("%v, GOMAXPROCS=%v", k.Machine, k.Procs)
+		}
+		var path []string
+		for _, r := range ordered[i] {
+			rev := byId[r.Rev]
+			p := &point{
+				X:     float64(c.Left) + float64(rev.Index-first)*xscale,
+				Y:     float64(c.Bottom) - (float64(r.Value)-lo)*yscale,
+				Href:  c.URL + "#rev-" + rev.Id,
+				Title: fmt.Sprintf("%v: %v\n%.12v %v\n%v", l.Name, m.Format(float64(r.Value)), rev.Id, rev.Author, rev.Desc),
+			}
+			l.Points = append(l.Points, p)
+			cmd := "L"
+			if len(path) == 0 {
+				cmd = "M"
+			}
+			path = append(path, fmt.Sprintf("%v%.1f %.1f", cmd, p.X, p.Y))
+			rw := rows[rev.Index]
+			if rw == nil {
+				rw = &row{Rev: rev, Values: make([]string, len(keys))}
+				rows[rev.Index] = rw
+			}
+			rw.Values[i] = m.Format(float64(r.Value))
+		}
+		l.Path = strings.Join(path, " ")
+		c.Lines = append(c.Lines, l)
+	}
+	for idx := last; idx >= first; idx-- {
+		if rw := rows[idx]; rw != nil {
+			c.Rows = append(c.Rows, rw)
+		}
+	}
+	return c
+}
+
+type lineKey struct {
+	Machine string
+	Procs   int
+}
+
+type lineKeySlice []lineKey
+
+func (p lineKeySlice) Len() int      { return len(p) }
+func (p lineKeySlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p lineKeySlice) Less(i, j int) bool {
+	if p[i].Machine != p[j].Machine {
+		return p[i].Machine < p[j].Machine
+	}
+	return p[i].Procs < p[j].Procs
+}
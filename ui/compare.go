@@ -0,0 +1,108 @@
+package ui
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"code.google.com/p/goperfd/regress"
+)
+
+type compareBench struct {
+	Name string
+	Rows []*compareRow
+}
+
+type compareRow struct {
+	Machine string
+	Procs   int
+	Metric  string
+	Chart   string
+	Old     string
+	New     string
+	OldRev  string // set if the old value comes from an older revision than A
+	NewRev  string // set if the new value comes from an older revision than B
+	Delta   string
+	Noise   string
+	Marker  string // * for significant changes, ? if the noise is unknown
+	Class   string // better, worse or noise
+}
+
+// handleCompare compares all benchmarks at revisions a and b.
+// b defaults to the branch head, so that /compare?a=X compares X with head.
+func (h *handlers) handleCompare(w http.ResponseWriter, r *http.Request) {
+	cfg := h.project.Get()
+	data := map[string]interface{}{
+		"Project": cfg.Name,
+		"Prefix":  h.prefix,
+		"A":       r.FormValue("a"),
+		"B":       r.FormValue("b"),
+	}
+	if r.FormValue("a") == "" {
+		serveTemplate(w, compareTemplate, data)
+		return
+	}
+	a, err := h.repo.Resolve(r.FormValue("a"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	b, err := h.repo.Resolve(r.FormValue("b"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	cmps, err := regress.Compare(cfg, h.store, h.repo.Revs(), a, b)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	var benchmarks []*compareBench
+	for _, c := range cmps {
+		if n := len(benchmarks); n == 0 || benchmarks[n-1].Name != c.Benchmark {
+			benchmarks = append(benchmarks, &compareBench{Name: c.Benchmark})
+		}
+		m := cfg.Metric(c.Metric)
+		row := &compareRow{
+			Machine: c.Machine,
+			Procs:   c.Procs,
+			Metric:  c.Metric,
+			Chart:   fmt.Sprintf("%v/chart?bench=%v&metric=%v&procs=%v", h.prefix, url.QueryEscape(c.Benchmark), url.QueryEscape(c.Metric), c.Procs),
+			Old:     m.Format(c.Old),
+			New:     m.Format(c.New),
+			Class:   "noise",
+		}
+		if c.RevA != a.Id {
+			row.OldRev = c.RevA
+		}
+		if c.RevB != b.Id {
+			row.NewRev = c.RevB
+		}
+		if c.Old != 0 {
+			row.Delta = fmt.Sprintf("%+.2f%%", c.Delta*100)
+		}
+		switch {
+		case c.Noise == 0:
+			row.Marker = "?"
+		case c.Significant:
+			row.Marker = "*"
+			row.Class = "better"
+			if c.Regression {
+				row.Class = "worse"
+			}
+		}
+		if c.Noise != 0 {
+			row.Noise = fmt.Sprintf("±%.2f%%", c.Noise*100)
+		}
+		bench := benchmarks[len(benchmarks)-1]
+		bench.Rows = append(bench.Rows, row)
+	}
+	// The permanent link refers to full ids, so it does not change when the branch moves.
+	query := url.Values{"a": {a.Id}, "b": {b.Id}}.Encode()
+	data["RevA"] = a
+	data["RevB"] = b
+	data["Benchmarks"] = benchmarks
+	data["Link"] = h.prefix + "/compare?" + query
+	data["API"] = h.prefix + "/api/compare?" + query
+	serveTemplate(w, compareTemplate, data)
+}
@@ -0,0 +1,69 @@
+package ui
+
+import (
+	"net/http"
+	"sort"
+
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/gc"
+)
+
+// gcRev summarizes artifacts of a revision that are deleted by garbage collection.
+type gcRev struct {
+	Project string
+	Rev     string
+	Files   int
+	Bytes   int64
+}
+
+// RegisterGC registers the page that shows what garbage collection would delete.
+// A dry run scans all artifacts and blobs, so it is run on POST only,
+// GET shows the report of the last dry run.
+func RegisterGC(c *gc.Collector) error {
+	http.HandleFunc("/gc", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			if _, err := c.Run(true); err != nil {
+				serveError(w, err)
+				return
+			}
+			http.Redirect(w, r, "/gc", http.StatusSeeOther)
+			return
+		}
+		var files []*db.File
+		rep := c.Preview()
+		if rep != nil {
+			files = rep.Files
+		}
+		byRev := make(map[gcRev]*gcRev)
+		var revs []*gcRev
+		for _, f := range files {
+			key := gcRev{Project: f.Project, Rev: f.Rev}
+			gr := byRev[key]
+			if gr == nil {
+				gr = &gcRev{Project: f.Project, Rev: f.Rev}
+				byRev[key] = gr
+				revs = append(revs, gr)
+			}
+			gr.Files++
+			gr.Bytes += f.Size
+		}
+		sort.Sort(gcRevSlice(revs))
+		serveTemplate(w, gcTemplate, map[string]interface{}{
+			"Report": rep,
+			"Last":   c.Last(),
+			"Revs":   revs,
+		})
+	})
+	return nil
+}
+
+type gcRevSlice []*gcRev
+
+func (p gcRevSlice) Len() int      { return len(p) }
+func (p gcRevSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p gcRevSlice) Less(i, j int) bool {
+	if p[i].Project != p[j].Project {
+		return p[i].Project < p[j].Project
+	}
+	return p[i].Rev < p[j].Rev
+}
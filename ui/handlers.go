@@ -1,15 +1,189 @@
+// Package ui implements the web interface of goperfd.
 package ui
 
 import (
 	"fmt"
+	"html/template"
+	"log"
 	"net/http"
+	"sort"
+	"strconv"
+
+	"code.google.com/p/goperfd/blob"
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/repo"
+	"code.google.com/p/goperfd/sched"
 )
 
-func RegisterHandlers() error {
-	http.HandleFunc("/", handleRoot)
+// handlers serves pages of a single project.
+type handlers struct {
+	project *config.ProjectFile
+	prefix  string // URL path of the project
+	store   db.Store
+	blobs   *blob.Store
+	repo    *repo.Repo
+	sched   *sched.Scheduler
+}
+
+// RegisterHandlers registers pages of the project under its URL path.
+func RegisterHandlers(project *config.ProjectFile, s db.Store, b *blob.Store, r *repo.Repo, sc *sched.Scheduler) error {
+	h := &handlers{
+		project: project,
+		prefix:  project.Get().URLPath(),
+		store:   s,
+		blobs:   b,
+		repo:    r,
+		sched:   sc,
+	}
+	http.HandleFunc(h.prefix+"/", h.handleRoot)
+	http.HandleFunc(h.prefix+"/bench", h.handleBench)
+	http.HandleFunc(h.prefix+"/chart", h.handleChart)
+	http.HandleFunc(h.prefix+"/rev", h.handleRev)
+	http.HandleFunc(h.prefix+"/file", h.handleFile)
+	http.HandleFunc(h.prefix+"/fleet", h.handleFleet)
+	http.HandleFunc(h.prefix+"/compare", h.handleCompare)
+	http.HandleFunc(h.prefix+"/profile", h.handleProfile)
+	return nil
+}
+
+// RegisterIndex registers the page that lists all projects.
+func RegisterIndex(projects []*config.ProjectFile) error {
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		var cfgs []*config.ProjectConfig
+		for _, p := range projects {
+			cfgs = append(cfgs, p.Get())
+		}
+		serveTemplate(w, indexTemplate, map[string]interface{}{
+			"Projects": cfgs,
+		})
+	})
 	return nil
 }
 
-func handleRoot(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "Hello!")
+func (h *handlers) handleRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != h.prefix+"/" {
+		http.NotFound(w, r)
+		return
+	}
+	cfg := h.project.Get()
+	results, err := h.store.Select(&db.Query{Project: cfg.Name})
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	type benchInfo struct {
+		config.Benchmark
+		Metrics []string
+	}
+	metrics := make(map[string]map[string]bool)
+	for _, r := range results {
+		if metrics[r.Benchmark] == nil {
+			metrics[r.Benchmark] = make(map[string]bool)
+		}
+		metrics[r.Benchmark][r.Metric] = true
+	}
+	var benchmarks []benchInfo
+	for _, b := range cfg.Benchmarks {
+		var mm []string
+		for m := range metrics[b.Name] {
+			mm = append(mm, m)
+		}
+		sort.Strings(mm)
+		benchmarks = append(benchmarks, benchInfo{b, mm})
+	}
+	serveTemplate(w, rootTemplate, map[string]interface{}{
+		"Project":    cfg.Name,
+		"Prefix":     h.prefix,
+		"Benchmarks": benchmarks,
+	})
+}
+
+func (h *handlers) handleBench(w http.ResponseWriter, r *http.Request) {
+	cfg := h.project.Get()
+	bench := r.FormValue("name")
+	results, err := h.store.Select(&db.Query{Project: cfg.Name, Benchmark: bench})
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	byMetric := make(map[string][]*db.Result)
+	for _, r := range results {
+		byMetric[r.Metric] = append(byMetric[r.Metric], r)
+	}
+	var metrics []string
+	for m := range byMetric {
+		metrics = append(metrics, m)
+	}
+	sort.Strings(metrics)
+	revs := h.repo.Revs()
+	var charts []*chart
+	for _, metric := range metrics {
+		url := fmt.Sprintf("%v/chart?bench=%v&metric=%v", h.prefix, template.URLQueryEscaper(bench), template.URLQueryEscaper(metric))
+		charts = append(charts, makeChart(metric, url, cfg.Metric(metric), byMetric[metric], revs, 480, 240))
+	}
+	serveTemplate(w, benchTemplate, map[string]interface{}{
+		"Project":   cfg.Name,
+		"Prefix":    h.prefix,
+		"Benchmark": bench,
+		"Charts":    charts,
+	})
+}
+
+func (h *handlers) handleChart(w http.ResponseWriter, r *http.Request) {
+	cfg := h.project.Get()
+	bench := r.FormValue("bench")
+	metric := r.FormValue("metric")
+	procs, _ := strconv.Atoi(r.FormValue("procs"))
+	results, err := h.store.Select(&db.Query{Project: cfg.Name, Benchmark: bench, Metric: metric, Procs: procs})
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	c := makeChart(bench+" "+metric, "", cfg.Metric(metric), results, h.repo.Revs(), 1000, 400)
+	serveTemplate(w, chartTemplate, map[string]interface{}{
+		"Project":   cfg.Name,
+		"Prefix":    h.prefix,
+		"Benchmark": bench,
+		"Metric":    metric,
+		"Chart":     c,
+	})
+}
+
+func (h *handlers) handleFleet(w http.ResponseWriter, r *http.Request) {
+	cfg := h.project.Get()
+	status, err := h.sched.Status(cfg)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	type machineInfo struct {
+		*sched.MachineStatus
+		Desc string
+	}
+	var machines []machineInfo
+	for i, st := range status {
+		machines = append(machines, machineInfo{st, cfg.Machines[i].Desc})
+	}
+	serveTemplate(w, fleetTemplate, map[string]interface{}{
+		"Project":  cfg.Name,
+		"Prefix":   h.prefix,
+		"Machines": machines,
+	})
+}
+
+func serveTemplate(w http.ResponseWriter, t *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("ui: failed to execute template %v: %v", t.Name(), err)
+	}
+}
+
+func serveError(w http.ResponseWriter, err error) {
+	log.Printf("ui: %v", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
@@ -0,0 +1,203 @@
+package ui
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/profile"
+	"code.google.com/p/goperfd/repo"
+)
+
+// rawProfiles maps names of rendered profiles to names of the raw profiles
+// and of the raw baselines that are subtracted from them (see bench/driver).
+var rawProfiles = map[string]struct{ raw, base string }{
+	"cpuprof": {"cpuprof-raw", ""},
+	"memprof": {"memprof-raw", "memprof0-raw"},
+}
+
+type profileRow struct {
+	Name   string
+	Old    string
+	New    string
+	OldCum string
+	NewCum string
+	Abs    string
+	Rel    string
+	Class  string // better or worse
+}
+
+// handleProfile compares a profile of a benchmark at revisions a and b.
+// b defaults to the branch head and a to the parent of b. If a revision has
+// no profile, the profile of the closest older revision is used.
+func (h *handlers) handleProfile(w http.ResponseWriter, r *http.Request) {
+	cfg := h.project.Get()
+	bench := r.FormValue("bench")
+	machine := r.FormValue("machine")
+	procs, _ := strconv.Atoi(r.FormValue("procs"))
+	name := r.FormValue("name")
+	if _, ok := rawProfiles[name]; !ok {
+		http.Error(w, fmt.Sprintf("unknown profile '%v'", name), http.StatusBadRequest)
+		return
+	}
+	b, err := h.repo.Resolve(r.FormValue("b"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	var a *repo.Rev
+	if r.FormValue("a") != "" {
+		a, err = h.repo.Resolve(r.FormValue("a"))
+	} else if len(b.Parents) != 0 {
+		a, err = h.repo.Resolve(b.Parents[0])
+	} else {
+		err = fmt.Errorf("revision %v has no parent", b.Id)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	key := &db.File{Project: cfg.Name, Benchmark: bench, Machine: machine, Procs: procs, Name: name}
+	revA, filesA, err := h.findFiles(key, a)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	revB, filesB, err := h.findFiles(key, b)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	if revA == nil || revB == nil {
+		http.Error(w, fmt.Sprintf("no %v for %v on %v at or before both revisions", name, bench, machine), http.StatusNotFound)
+		return
+	}
+	// Raw profiles are preferred, but the text renderings are used
+	// unless both raw profiles are usable, so that function names match.
+	profA, errA := h.loadProfile(filesA, name, true)
+	profB, errB := h.loadProfile(filesB, name, true)
+	if errA != nil || errB != nil {
+		if profA, err = h.loadProfile(filesA, name, false); err == nil {
+			profB, err = h.loadProfile(filesB, name, false)
+		}
+		if err != nil {
+			serveError(w, err)
+			return
+		}
+	}
+	deltas := profile.Diff(profA, profB)
+	sortBy := r.FormValue("sort")
+	if sortBy == "rel" {
+		sort.Sort(profile.ByRel(deltas))
+	} else {
+		sortBy = "abs"
+		sort.Sort(profile.ByAbs(deltas))
+	}
+	var rows []*profileRow
+	for _, d := range deltas {
+		row := &profileRow{
+			Name:   d.Name,
+			Old:    fmt.Sprintf("%.2f%%", d.Old*100),
+			New:    fmt.Sprintf("%.2f%%", d.New*100),
+			OldCum: fmt.Sprintf("%.2f%%", d.OldCum*100),
+			NewCum: fmt.Sprintf("%.2f%%", d.NewCum*100),
+			Abs:    fmt.Sprintf("%+.2f", d.Abs*100),
+		}
+		switch {
+		case math.IsInf(d.Rel, 1):
+			row.Rel = "new"
+		case d.Old != 0:
+			row.Rel = fmt.Sprintf("%+.1f%%", d.Rel*100)
+		}
+		switch {
+		case d.Abs > 0:
+			row.Class = "worse"
+		case d.Abs < 0:
+			row.Class = "better"
+		}
+		rows = append(rows, row)
+	}
+	query := url.Values{"bench": {bench}, "machine": {machine}, "procs": {strconv.Itoa(procs)}, "name": {name}, "a": {a.Id}, "b": {b.Id}}
+	unit := &config.Metric{Unit: profA.Unit}
+	serveTemplate(w, profileTemplate, map[string]interface{}{
+		"Project":   cfg.Name,
+		"Prefix":    h.prefix,
+		"Benchmark": bench,
+		"Machine":   machine,
+		"Procs":     procs,
+		"Name":      name,
+		"RevA":      a,
+		"RevB":      b,
+		"ProfA":     revA,
+		"ProfB":     revB,
+		"TotalA":    unit.Format(float64(profA.Total)),
+		"TotalB":    unit.Format(float64(profB.Total)),
+		"Sort":      sortBy,
+		"Link":      h.prefix + "/profile?" + query.Encode(),
+		"Rows":      rows,
+	})
+}
+
+// findFiles returns artifacts of the benchmark run that is described by key
+// at the revision or the closest older revision that has the profile key.Name.
+// The store indexes files by revision, so each step of the walk is cheap.
+func (h *handlers) findFiles(key *db.File, rev *repo.Rev) (*repo.Rev, map[string]*db.File, error) {
+	revs := h.repo.Revs()
+	if !repo.In(revs, rev) {
+		return nil, nil, fmt.Errorf("revision %v is not in the history", rev.Id)
+	}
+	for i := rev.Index; i >= 0; i-- {
+		files, err := h.store.Files(key.Project, revs[i].Id)
+		if err != nil {
+			return nil, nil, err
+		}
+		res := make(map[string]*db.File)
+		for _, f := range files {
+			if f.Benchmark == key.Benchmark && f.Machine == key.Machine && f.Procs == key.Procs {
+				res[f.Name] = f
+			}
+		}
+		if res[key.Name] != nil {
+			return revs[i], res, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// loadProfile parses the profile name, either the raw one or its text rendering.
+func (h *handlers) loadProfile(files map[string]*db.File, name string, raw bool) (*profile.Profile, error) {
+	if !raw {
+		return h.parseFile(files[name])
+	}
+	names := rawProfiles[name]
+	p, err := h.parseFile(files[names.raw])
+	if err != nil || names.base == "" {
+		return p, err
+	}
+	base, err := h.parseFile(files[names.base])
+	if err != nil {
+		return nil, err
+	}
+	p.Sub(base)
+	return p, nil
+}
+
+func (h *handlers) parseFile(f *db.File) (*profile.Profile, error) {
+	if f == nil {
+		return nil, fmt.Errorf("no such profile")
+	}
+	data, err := h.blobs.Get(f.Hash)
+	if err != nil {
+		return nil, err
+	}
+	p, err := profile.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %v at %v: %v", f.Name, f.Rev, err)
+	}
+	return p, nil
+}
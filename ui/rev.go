@@ -0,0 +1,204 @@
+package ui
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"code.google.com/p/goperfd/config"
+	"code.google.com/p/goperfd/db"
+	"code.google.com/p/goperfd/regress"
+	"code.google.com/p/goperfd/repo"
+)
+
+type revBench struct {
+	Name  string
+	Rows  []*revRow
+	Files []*revFiles
+}
+
+// revRow compares a metric at the revision with its parent.
+type revRow struct {
+	Machine string
+	Procs   int
+	Metric  string
+	Chart   string // link to the chart
+	Base    *repo.Rev
+	Old     string
+	New     string
+	Delta   string
+	Noise   string
+	Class   string // better, worse or noise
+}
+
+type revFiles struct {
+	Machine  string
+	Procs    int
+	Files    []*db.File
+	Profiles []string // profiles that can be compared with the parent
+}
+
+func (h *handlers) handleRev(w http.ResponseWriter, r *http.Request) {
+	rev := h.repo.Rev(r.FormValue("id"))
+	if rev == nil {
+		http.Error(w, fmt.Sprintf("unknown revision '%v'", r.FormValue("id")), http.StatusNotFound)
+		return
+	}
+	cfg := h.project.Get()
+	project := cfg.Name
+	results, err := h.store.Revision(project, rev.Id)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	files, err := h.store.Files(project, rev.Id)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	revs := h.repo.Revs()
+	if !repo.In(revs, rev) {
+		serveError(w, fmt.Errorf("revision %v is not in the history", rev.Id))
+		return
+	}
+	var parents []*repo.Rev
+	for _, id := range rev.Parents {
+		if p := h.repo.Rev(id); p != nil {
+			parents = append(parents, p)
+		}
+	}
+	var benchmarks []*revBench
+	byName := make(map[string]*revBench)
+	getBench := func(name string) *revBench {
+		b := byName[name]
+		if b == nil {
+			b = &revBench{Name: name}
+			byName[name] = b
+			benchmarks = append(benchmarks, b)
+		}
+		return b
+	}
+	for _, res := range results {
+		row, err := h.compare(cfg.Metric(res.Metric), res, rev, revs)
+		if err != nil {
+			serveError(w, err)
+			return
+		}
+		b := getBench(res.Benchmark)
+		b.Rows = append(b.Rows, row)
+	}
+	for _, f := range files {
+		b := getBench(f.Benchmark)
+		if n := len(b.Files); n == 0 || b.Files[n-1].Machine != f.Machine || b.Files[n-1].Procs != f.Procs {
+			b.Files = append(b.Files, &revFiles{Machine: f.Machine, Procs: f.Procs})
+		}
+		fs := b.Files[len(b.Files)-1]
+		fs.Files = append(fs.Files, f)
+		if _, ok := rawProfiles[f.Name]; ok && len(rev.Parents) != 0 {
+			fs.Profiles = append(fs.Profiles, f.Name)
+		}
+	}
+	serveTemplate(w, revTemplate, map[string]interface{}{
+		"Project":    project,
+		"Prefix":     h.prefix,
+		"Rev":        rev,
+		"Parents":    parents,
+		"Benchmarks": benchmarks,
+	})
+}
+
+// compare compares the result with the result for the parent revision.
+// If the parent is not benchmarked, the closest older benchmarked revision is used.
+// Changes within the noise or below the metric threshold are not coloured.
+func (h *handlers) compare(m *config.Metric, res *db.Result, rev *repo.Rev, revs []*repo.Rev) (*revRow, error) {
+	row := &revRow{
+		Machine: res.Machine,
+		Procs:   res.Procs,
+		Metric:  res.Metric,
+		Chart:   fmt.Sprintf("%v/chart?bench=%v&metric=%v&procs=%v#rev-%v", h.prefix, url.QueryEscape(res.Benchmark), url.QueryEscape(res.Metric), res.Procs, rev.Id),
+		New:     m.Format(float64(res.Value)),
+	}
+	series, err := h.store.Series(res.Project, res.Benchmark, res.Metric, res.Machine, res.Procs)
+	if err != nil {
+		return nil, err
+	}
+	history := regress.Order(series, revs[:rev.Index])
+	if len(history) == 0 {
+		return row, nil
+	}
+	base := history[len(history)-1]
+	if len(rev.Parents) != 0 {
+		for _, r := range history {
+			if r.Rev == rev.Parents[0] {
+				base = r
+			}
+		}
+	}
+	row.Base = h.repo.Rev(base.Rev)
+	row.Old = m.Format(float64(base.Value))
+	if base.Value == 0 {
+		return row, nil
+	}
+	delta := (float64(res.Value) - float64(base.Value)) / float64(base.Value)
+	row.Delta = fmt.Sprintf("%+.2f%%", delta*100)
+	values := make([]float64, len(history))
+	for i, r := range history {
+		values[i] = float64(r.Value)
+	}
+	noise, ok := regress.Noise(values)
+	if !ok {
+		return row, nil
+	}
+	row.Noise = fmt.Sprintf("±%.2f%%", noise*100)
+	switch {
+	case math.Abs(delta) <= noise || math.Abs(delta) < m.Threshold:
+		row.Class = "noise"
+	case m.Worse(float64(base.Value), float64(res.Value)):
+		row.Class = "worse"
+	default:
+		row.Class = "better"
+	}
+	return row, nil
+}
+
+func (h *handlers) handleFile(w http.ResponseWriter, r *http.Request) {
+	procs, _ := strconv.Atoi(r.FormValue("procs"))
+	files, err := h.store.Files(h.project.Get().Name, r.FormValue("rev"))
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	for _, f := range files {
+		if f.Benchmark != r.FormValue("bench") || f.Machine != r.FormValue("machine") || f.Procs != procs || f.Name != r.FormValue("name") {
+			continue
+		}
+		data, err := h.blobs.Get(f.Hash)
+		if err != nil {
+			serveError(w, err)
+			return
+		}
+		serveFile(w, data)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// serveFile serves an artifact uploaded by a builder.
+// Artifacts are not trusted, so nothing except svg profiles is rendered by the browser.
+func serveFile(w http.ResponseWriter, data []byte) {
+	ctype := "text/plain; charset=utf-8"
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	if bytes.Contains(head, []byte("<svg")) {
+		ctype = "image/svg+xml"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(data)
+}
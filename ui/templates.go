@@ -0,0 +1,253 @@
+package ui
+
+import (
+	"fmt"
+	"html/template"
+	"time"
+)
+
+func parseTemplate(name, text string) *template.Template {
+	t := template.New(name).Funcs(template.FuncMap{
+		"short": func(id string) string {
+			if len(id) > 10 {
+				return id[:10]
+			}
+			return id
+		},
+		"firstLine": func(s string) string {
+			for i, c := range s {
+				if c == '\n' {
+					return s[:i]
+				}
+			}
+			return s
+		},
+		"ago": func(t time.Time) string {
+			if t.IsZero() {
+				return "never"
+			}
+			d := time.Since(t)
+			switch {
+			case d < time.Minute:
+				return fmt.Sprintf("%vs ago", int(d.Seconds()))
+			case d < time.Hour:
+				return fmt.Sprintf("%vm ago", int(d.Minutes()))
+			case d < 48*time.Hour:
+				return fmt.Sprintf("%vh ago", int(d.Hours()))
+			default:
+				return fmt.Sprintf("%vd ago", int(d.Hours()/24))
+			}
+		},
+		"percent": func(v float64) string {
+			return fmt.Sprintf("%.0f%%", v*100)
+		},
+	})
+	template.Must(t.Parse(layoutText))
+	return template.Must(t.Parse(text))
+}
+
+const layoutText = `
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{with .Project}}{{.}} performance{{else}}goperfd{{end}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 1em 2em; }
+a { color: #375eab; text-decoration: none; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 8px; text-align: left; vertical-align: top; }
+tr:target { background: #ffe680; }
+td.num, th.num { text-align: right; font-family: monospace; }
+.chart { display: inline-block; margin: 0 1em 1em 0; }
+.chart text { font-size: 11px; fill: #444; }
+.chart .grid { stroke: #ddd; }
+.chart circle { cursor: pointer; }
+.chart circle:hover { r: 6; }
+.worse { color: #c00; }
+.better { color: #080; }
+.noise { color: #888; }
+.legend span { display: inline-block; width: 12px; height: 12px; margin: 0 4px 0 12px; }
+</style>
+</head>
+<body>
+{{with .Project}}<h2><a href="{{$.Prefix}}/">{{.}} performance</a> <small><a href="{{$.Prefix}}/compare">compare</a> <a href="{{$.Prefix}}/fleet">machines</a></small></h2>{{end}}
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "plot"}}<div class="chart">
+<div><b>{{if .URL}}<a href="{{.URL}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</b>
+<span class="legend">{{range .Lines}}<span style="background: {{.Color}}"></span>{{.Name}}{{end}}</span></div>
+<svg width="{{.Width}}" height="{{.Height}}">
+{{$c := .}}{{range .YTicks}}<line class="grid" x1="{{$c.Left}}" x2="{{$c.Right}}" y1="{{.Y}}" y2="{{.Y}}"/>
+<text x="{{$c.Left}}" y="{{.Y}}" dx="-4" dy="4" text-anchor="end">{{.Label}}</text>
+{{end}}{{range .Lines}}{{$color := .Color}}<path d="{{.Path}}" stroke="{{$color}}" stroke-width="1.5" fill="none"/>
+{{range .Points}}<a href="{{.Href}}"><circle cx="{{.X}}" cy="{{.Y}}" r="3" fill="{{$color}}"><title>{{.Title}}</title></circle></a>
+{{end}}{{end}}</svg>
+</div>{{end}}
+`
+
+var indexTemplate = parseTemplate("index.html", `{{template "header" .}}
+<h2>Projects</h2>
+<table>
+{{range .Projects}}<tr>
+<td><a href="{{.URLPath}}/"><b>{{.Name}}</b></a></td>
+<td>{{.Repo}}</td>
+</tr>
+{{end}}</table>
+<p><a href="/gc">Storage garbage collection</a></p>
+{{template "footer" .}}`)
+
+var rootTemplate = parseTemplate("root.html", `{{template "header" .}}
+<table>
+{{range .Benchmarks}}<tr>
+<td><a href="{{$.Prefix}}/bench?name={{.Name}}"><b>{{.Name}}</b></a></td>
+<td>{{.Desc}}</td>
+<td>{{$b := .Name}}{{range .Metrics}}<a href="{{$.Prefix}}/chart?bench={{$b}}&metric={{.}}">{{.}}</a> {{end}}</td>
+</tr>
+{{end}}</table>
+{{template "footer" .}}`)
+
+var benchTemplate = parseTemplate("bench.html", `{{template "header" .}}
+<h3>{{.Benchmark}}</h3>
+{{range .Charts}}{{template "plot" .}}{{else}}No results yet.{{end}}
+{{template "footer" .}}`)
+
+var chartTemplate = parseTemplate("chart.html", `{{template "header" .}}
+<h3><a href="{{$.Prefix}}/bench?name={{.Benchmark}}">{{.Benchmark}}</a> {{.Metric}}</h3>
+{{template "plot" .Chart}}
+<table>
+<tr><th>Revision</th><th>Time</th><th>Author</th><th>Description</th>{{range .Chart.Lines}}<th class="num">{{.Name}}</th>{{end}}</tr>
+{{range .Chart.Rows}}<tr id="rev-{{.Rev.Id}}">
+<td><a href="{{$.Prefix}}/rev?id={{.Rev.Id}}"><code>{{short .Rev.Id}}</code></a></td>
+<td>{{.Rev.Time.Format "2006-01-02 15:04"}}</td>
+<td>{{.Rev.Author}}</td>
+<td>{{firstLine .Rev.Desc}}</td>
+{{range .Values}}<td class="num">{{.}}</td>{{end}}
+</tr>
+{{end}}</table>
+{{template "footer" .}}`)
+
+var revTemplate = parseTemplate("rev.html", `{{template "header" .}}
+<h3>Revision <code>{{.Rev.Id}}</code></h3>
+<table>
+<tr><td>Author</td><td>{{.Rev.Author}}</td></tr>
+<tr><td>Time</td><td>{{.Rev.Time.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><td>Parents</td><td>{{range .Parents}}<a href="{{$.Prefix}}/rev?id={{.Id}}"><code>{{short .Id}}</code></a> {{end}}</td></tr>
+</table>
+<pre>{{.Rev.Desc}}</pre>
+<p><a href="{{$.Prefix}}/compare?a={{.Rev.Id}}">Compare with the branch head</a></p>
+{{range .Benchmarks}}<h3><a href="{{$.Prefix}}/bench?name={{.Name}}">{{.Name}}</a></h3>
+<table>
+<tr><th>Machine</th><th class="num">GOMAXPROCS</th><th>Metric</th><th>Base</th><th class="num">Old</th><th class="num">New</th><th class="num">Delta</th><th class="num">Noise</th></tr>
+{{range .Rows}}<tr class="{{.Class}}">
+<td>{{.Machine}}</td>
+<td class="num">{{.Procs}}</td>
+<td><a href="{{.Chart}}">{{.Metric}}</a></td>
+<td>{{with .Base}}<a href="{{$.Prefix}}/rev?id={{.Id}}"><code>{{short .Id}}</code></a>{{end}}</td>
+<td class="num">{{.Old}}</td>
+<td class="num">{{.New}}</td>
+<td class="num">{{.Delta}}</td>
+<td class="num">{{.Noise}}</td>
+</tr>
+{{end}}</table>
+{{$b := .Name}}{{range .Files}}<p>Artifacts for {{.Machine}}, GOMAXPROCS={{.Procs}}:
+{{$f := .}}{{range .Files}}<a href="{{$.Prefix}}/file?rev={{.Rev}}&bench={{$b}}&machine={{$f.Machine}}&procs={{$f.Procs}}&name={{.Name}}">{{.Name}}</a> {{end}}
+{{range .Profiles}}<a href="{{$.Prefix}}/profile?bench={{$b}}&machine={{$f.Machine}}&procs={{$f.Procs}}&name={{.}}&b={{$.Rev.Id}}">{{.}} diff with parent</a> {{end}}</p>
+{{end}}{{else}}<p>No results for this revision.</p>
+{{end}}{{template "footer" .}}`)
+
+var fleetTemplate = parseTemplate("fleet.html", `{{template "header" .}}
+<h3>Machines</h3>
+<table>
+<tr><th>Machine</th><th>Last seen</th><th>Running</th><th class="num">Stuck</th><th class="num">Queue</th><th class="num">Failure rate</th></tr>
+{{range .Machines}}<tr{{if .Silent}} class="worse" title="the machine has stopped reporting"{{end}}>
+<td><b>{{.Name}}</b>{{with .Desc}}<br>{{.}}{{end}}</td>
+<td>{{ago .LastSeen}}{{if .Silent}} (silent){{end}}</td>
+<td>{{range .Jobs}}{{.Benchmark}}@<a href="{{$.Prefix}}/rev?id={{.Rev}}"><code>{{short .Rev}}</code></a>
+{{with .Status}}{{.}}, {{end}}{{percent .Progress}}, started {{ago .Started}}, heartbeat {{ago .Heartbeat}}<br>
+{{else}}idle{{end}}</td>
+<td class="num{{if .Stuck}} worse{{end}}">{{len .Stuck}}</td>
+<td class="num">{{.Queue}}</td>
+<td class="num">{{percent .FailureRate}} ({{.Failed}} failed, {{.Done}} ok)</td>
+</tr>
+{{end}}</table>
+{{template "footer" .}}`)
+
+var compareTemplate = parseTemplate("compare.html", `{{template "header" .}}
+<h3>Compare revisions</h3>
+<form action="{{.Prefix}}/compare" method="GET">
+A <input name="a" value="{{.A}}" size="42" placeholder="revision, branch or tag">
+B <input name="b" value="{{.B}}" size="42" placeholder="branch head">
+<input type="submit" value="Compare">
+</form>
+{{with .RevA}}<table>
+<tr><td>A</td><td><a href="{{$.Prefix}}/rev?id={{.Id}}"><code>{{short .Id}}</code></a></td><td>{{.Time.Format "2006-01-02 15:04"}}</td><td>{{.Author}}</td><td>{{firstLine .Desc}}</td></tr>
+{{with $.RevB}}<tr><td>B</td><td><a href="{{$.Prefix}}/rev?id={{.Id}}"><code>{{short .Id}}</code></a></td><td>{{.Time.Format "2006-01-02 15:04"}}</td><td>{{.Author}}</td><td>{{firstLine .Desc}}</td></tr>{{end}}
+</table>
+<p><a href="{{$.Link}}">Permanent link</a>, <a href="{{$.API}}">JSON</a>, <a href="{{$.API}}&format=csv">CSV</a></p>
+{{range $.Benchmarks}}<h3><a href="{{$.Prefix}}/bench?name={{.Name}}">{{.Name}}</a></h3>
+<table>
+<tr><th>Machine</th><th class="num">GOMAXPROCS</th><th>Metric</th><th class="num">A</th><th class="num">B</th><th class="num">Delta</th><th class="num">Noise</th><th></th></tr>
+{{range .Rows}}<tr class="{{.Class}}">
+<td>{{.Machine}}</td>
+<td class="num">{{.Procs}}</td>
+<td><a href="{{.Chart}}">{{.Metric}}</a></td>
+<td class="num">{{.Old}}{{with .OldRev}}<sup title="A is not benchmarked, the value is for {{short .}}">†</sup>{{end}}</td>
+<td class="num">{{.New}}{{with .NewRev}}<sup title="B is not benchmarked, the value is for {{short .}}">†</sup>{{end}}</td>
+<td class="num">{{.Delta}}</td>
+<td class="num">{{.Noise}}</td>
+<td>{{.Marker}}</td>
+</tr>
+{{end}}</table>
+{{else}}<p>No results to compare.</p>
+{{end}}<p>* the change exceeds the noise of the series and the metric threshold;
+? too few results to estimate the noise; † the value is for the closest older benchmarked revision.</p>
+{{end}}{{template "footer" .}}`)
+
+var gcTemplate = parseTemplate("gc.html", `{{template "header" .}}
+<h2><a href="/">Projects</a> / garbage collection</h2>
+{{with .Last}}<p>The last collection ran {{ago .Time}} and deleted {{len .Files}} artifacts ({{.FileBytes}} bytes)
+and {{.Blobs}} blobs ({{.BlobBytes}} bytes), {{.Kept}} artifacts were kept.</p>
+{{else}}<p>Garbage collection did not run yet.</p>
+{{end}}{{with .Report}}<p>If it ran {{ago .Time}}, it would have deleted {{len .Files}} artifacts ({{.FileBytes}} bytes)
+and {{.Blobs}} unreferenced blobs ({{.BlobBytes}} bytes), and kept {{.Kept}} artifacts.</p>{{end}}
+<form method="POST" action="/gc"><input type="submit" value="Check what it would delete now"></form>
+<table>
+<tr><th>Project</th><th>Revision</th><th class="num">Artifacts</th><th class="num">Bytes</th></tr>
+{{range .Revs}}<tr>
+<td>{{.Project}}</td>
+<td><a href="/{{.Project}}/rev?id={{.Rev}}"><code>{{short .Rev}}</code></a></td>
+<td class="num">{{.Files}}</td>
+<td class="num">{{.Bytes}}</td>
+</tr>
+{{end}}</table>
+{{template "footer" .}}`)
+
+var profileTemplate = parseTemplate("profile.html", `{{template "header" .}}
+<h3><a href="{{$.Prefix}}/bench?name={{.Benchmark}}">{{.Benchmark}}</a> {{.Name}} on {{.Machine}}, GOMAXPROCS={{.Procs}}</h3>
+<table>
+<tr><td>A</td><td><a href="{{$.Prefix}}/rev?id={{.ProfA.Id}}"><code>{{short .ProfA.Id}}</code></a>{{if ne .ProfA.Id .RevA.Id}}<sup title="{{short .RevA.Id}} has no profile, the profile is for the closest older revision">†</sup>{{end}}</td><td>{{.ProfA.Time.Format "2006-01-02 15:04"}}</td><td>{{.ProfA.Author}}</td><td>{{firstLine .ProfA.Desc}}</td><td class="num">{{.TotalA}}</td></tr>
+<tr><td>B</td><td><a href="{{$.Prefix}}/rev?id={{.ProfB.Id}}"><code>{{short .ProfB.Id}}</code></a>{{if ne .ProfB.Id .RevB.Id}}<sup title="{{short .RevB.Id}} has no profile, the profile is for the closest older revision">†</sup>{{end}}</td><td>{{.ProfB.Time.Format "2006-01-02 15:04"}}</td><td>{{.ProfB.Author}}</td><td>{{firstLine .ProfB.Desc}}</td><td class="num">{{.TotalB}}</td></tr>
+</table>
+<p><a href="{{$.Link}}">Permanent link</a>. Sort by
+{{if eq .Sort "abs"}}<b>absolute</b>{{else}}<a href="{{$.Link}}&sort=abs">absolute</a>{{end}} or
+{{if eq .Sort "rel"}}<b>relative</b>{{else}}<a href="{{$.Link}}&sort=rel">relative</a>{{end}} change.</p>
+<table>
+<tr><th>Function</th><th class="num">A flat</th><th class="num">B flat</th><th class="num">Delta, pp</th><th class="num">Relative</th><th class="num">A cum</th><th class="num">B cum</th></tr>
+{{range .Rows}}<tr class="{{.Class}}">
+<td><code>{{.Name}}</code></td>
+<td class="num">{{.Old}}</td>
+<td class="num">{{.New}}</td>
+<td class="num">{{.Abs}}</td>
+<td class="num">{{.Rel}}</td>
+<td class="num">{{.OldCum}}</td>
+<td class="num">{{.NewCum}}</td>
+</tr>
+{{else}}<tr><td>The profiles are empty.</td></tr>
+{{end}}</table>
+<p>Costs are shares of the profile totals, deltas are in percentage points.</p>
+{{template "footer" .}}`)